@@ -2,13 +2,17 @@ package api
 
 import (
 	"fmt"
+	"strconv"
 
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
 type ErrOffsetOutOfRange struct {
 	Offset uint64
+	// Lowest is set when the offset is below the lowest offset, i.e. the record was removed by the retention policy
+	Lowest uint64
 }
 
 func (e ErrOffsetOutOfRange) GRPCStatus() *status.Status {
@@ -17,6 +21,14 @@ func (e ErrOffsetOutOfRange) GRPCStatus() *status.Status {
 		"The requests offset is outside the log's range: %d",
 		e.Offset,
 	)
+	if e.Offset < e.Lowest {
+		st = status.Newf(404, "offset out of range: %d, lowest offset: %d", e.Offset, e.Lowest)
+		msg = fmt.Sprintf(
+			"The requests offset %d was removed from the log, the lowest offset is %d",
+			e.Offset,
+			e.Lowest,
+		)
+	}
 	d := &errdetails.LocalizedMessage{
 		Locale:  "en-US",
 		Message: msg,
@@ -25,6 +37,16 @@ func (e ErrOffsetOutOfRange) GRPCStatus() *status.Status {
 	if err != nil {
 		return st
 	}
+	if e.Offset < e.Lowest {
+		// clients read it back with LowestOffset
+		std, err = std.WithDetails(&errdetails.ErrorInfo{
+			Reason:   offsetRemovedReason,
+			Metadata: map[string]string{"lowest": strconv.FormatUint(e.Lowest, 10)},
+		})
+		if err != nil {
+			return st
+		}
+	}
 
 	return std
 }
@@ -32,3 +54,316 @@ func (e ErrOffsetOutOfRange) GRPCStatus() *status.Status {
 func (e ErrOffsetOutOfRange) Error() string {
 	return e.GRPCStatus().Err().Error()
 }
+
+const offsetRemovedReason = "OFFSET_REMOVED"
+
+// LowestOffset returns the lowest offset of the log when err is the status of an ErrOffsetOutOfRange whose offset was
+// removed from the log, as received by a client.
+func LowestOffset(err error) (uint64, bool) {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok && info.Reason == offsetRemovedReason {
+			lowest, err := strconv.ParseUint(info.Metadata["lowest"], 10, 64)
+			return lowest, err == nil
+		}
+	}
+
+	return 0, false
+}
+
+// ErrCorruptRecord is returned when the stored frame of a record fails its checksum or cannot be decoded.
+type ErrCorruptRecord struct {
+	Offset     uint64
+	BaseOffset uint64
+	Position   uint64
+}
+
+func (e ErrCorruptRecord) GRPCStatus() *status.Status {
+	st := status.Newf(
+		codes.DataLoss,
+		"corrupt record: offset %d, segment %d, position %d",
+		e.Offset,
+		e.BaseOffset,
+		e.Position,
+	)
+	msg := fmt.Sprintf(
+		"The record at offset %d is corrupted on disk (segment %d, position %d)",
+		e.Offset,
+		e.BaseOffset,
+		e.Position,
+	)
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: msg,
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+
+	return std
+}
+
+func (e ErrCorruptRecord) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrBatchTooLarge is returned when a batch takes more bytes or index entries than a single segment can hold.
+type ErrBatchTooLarge struct {
+	Records int
+}
+
+func (e ErrBatchTooLarge) GRPCStatus() *status.Status {
+	st := status.Newf(codes.InvalidArgument, "batch too large: %d records", e.Records)
+	msg := fmt.Sprintf(
+		"The batch of %d records does not fit in a single segment",
+		e.Records,
+	)
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: msg,
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+
+	return std
+}
+
+func (e ErrBatchTooLarge) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrTopicNotFound is returned when using a topic which was not created.
+type ErrTopicNotFound struct {
+	Topic string
+}
+
+func (e ErrTopicNotFound) GRPCStatus() *status.Status {
+	st := status.Newf(codes.NotFound, "topic not found: %s", e.Topic)
+	msg := fmt.Sprintf(
+		"The topic %s does not exist",
+		e.Topic,
+	)
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: msg,
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+
+	return std
+}
+
+func (e ErrTopicNotFound) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrTopicExists is returned when creating a topic which already exists.
+type ErrTopicExists struct {
+	Topic string
+}
+
+func (e ErrTopicExists) GRPCStatus() *status.Status {
+	st := status.Newf(codes.AlreadyExists, "topic exists: %s", e.Topic)
+	msg := fmt.Sprintf(
+		"The topic %s already exists",
+		e.Topic,
+	)
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: msg,
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+
+	return std
+}
+
+func (e ErrTopicExists) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrInvalidTopic is returned when a topic name is not valid or the operation is not allowed on the topic.
+type ErrInvalidTopic struct {
+	Topic  string
+	Reason string
+}
+
+func (e ErrInvalidTopic) GRPCStatus() *status.Status {
+	st := status.Newf(codes.InvalidArgument, "invalid topic %q: %s", e.Topic, e.Reason)
+	msg := fmt.Sprintf(
+		"The topic %q cannot be used: %s",
+		e.Topic,
+		e.Reason,
+	)
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: msg,
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+
+	return std
+}
+
+func (e ErrInvalidTopic) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrPartitionNotFound is returned when using a partition a topic does not have.
+type ErrPartitionNotFound struct {
+	Topic     string
+	Partition uint32
+}
+
+func (e ErrPartitionNotFound) GRPCStatus() *status.Status {
+	st := status.Newf(codes.NotFound, "partition not found: %s/%d", e.Topic, e.Partition)
+	msg := fmt.Sprintf(
+		"The topic %s has no partition %d",
+		e.Topic,
+		e.Partition,
+	)
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: msg,
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+
+	return std
+}
+
+func (e ErrPartitionNotFound) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrInvalidGroup is returned when a consumer group name cannot be used or a request to join a group is invalid.
+type ErrInvalidGroup struct {
+	Group  string
+	Reason string
+}
+
+func (e ErrInvalidGroup) GRPCStatus() *status.Status {
+	st := status.Newf(codes.InvalidArgument, "invalid group %q: %s", e.Group, e.Reason)
+	msg := fmt.Sprintf(
+		"The request to the group %q is invalid: %s",
+		e.Group,
+		e.Reason,
+	)
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: msg,
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+
+	return std
+}
+
+func (e ErrInvalidGroup) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrOffsetNotCommitted is returned when fetching the offset of a partition the consumer group never committed one for.
+type ErrOffsetNotCommitted struct {
+	Group     string
+	Topic     string
+	Partition uint32
+}
+
+func (e ErrOffsetNotCommitted) GRPCStatus() *status.Status {
+	st := status.Newf(codes.NotFound, "offset not committed: group %s, partition %s/%d", e.Group, e.Topic, e.Partition)
+	msg := fmt.Sprintf(
+		"The group %s committed no offset for the partition %d of the topic %s",
+		e.Group,
+		e.Partition,
+		e.Topic,
+	)
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: msg,
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+
+	return std
+}
+
+func (e ErrOffsetNotCommitted) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrUnknownMember is returned when a consumer group does not know the member, which left the group, was expired or
+// never joined. The member has to join again without its id.
+type ErrUnknownMember struct {
+	Group    string
+	MemberID string
+}
+
+func (e ErrUnknownMember) GRPCStatus() *status.Status {
+	st := status.Newf(codes.NotFound, "unknown member: group %s, member %q", e.Group, e.MemberID)
+	msg := fmt.Sprintf(
+		"The group %s has no member %q, join the group again",
+		e.Group,
+		e.MemberID,
+	)
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: msg,
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+
+	return std
+}
+
+func (e ErrUnknownMember) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrIllegalGeneration is returned when a member of a consumer group uses an assignment the group replaced since. The
+// member has to join again to get its new assignment.
+type ErrIllegalGeneration struct {
+	Group      string
+	Generation uint64
+	Current    uint64
+}
+
+func (e ErrIllegalGeneration) GRPCStatus() *status.Status {
+	st := status.Newf(codes.FailedPrecondition, "illegal generation: group %s, generation %d, current generation %d", e.Group, e.Generation, e.Current)
+	msg := fmt.Sprintf(
+		"The group %s was rebalanced from generation %d to %d, join the group again",
+		e.Group,
+		e.Generation,
+		e.Current,
+	)
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: msg,
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+
+	return std
+}
+
+func (e ErrIllegalGeneration) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
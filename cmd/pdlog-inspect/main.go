@@ -0,0 +1,241 @@
+// pdlog-inspect inspects and repairs the data directory of a log which is not running.
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vlamug/pdlog/api/v1"
+	logpkg "github.com/vlamug/pdlog/internal/log"
+)
+
+// defaultStoreDir is the data directory of the first partition of the default topic of a server run with its defaults.
+const defaultStoreDir = "/store/default/0"
+
+var (
+	storeDir           = flag.String("dir", defaultStoreDir, "data directory of the log, the one of a partition of a topic")
+	indexIntervalBytes = flag.Uint64("index_interval_bytes", 0, "store bytes between two index entries of rebuilt indexes, zero indexes every record")
+	keys               keyFlag
+)
+
+func init() {
+	flag.Var(&keys, "key", "encryption key as id=hex, may be repeated")
+
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "usage: %s [flags] <command> [args]\n\n", os.Args[0])
+		fmt.Fprintln(out, "commands:")
+		fmt.Fprintln(out, "  list                     list the segments with their offsets and sizes")
+		fmt.Fprintln(out, "  dump [-from N] [-to N]   print the records in [from, to) as JSON, one per line")
+		fmt.Fprintln(out, "  verify [base]            check the index entries of every segment, or of the given one")
+		fmt.Fprintln(out, "  rebuild-index <base>     rebuild the index of the segment from its store")
+		fmt.Fprintln(out, "\nflags:")
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c := logpkg.Config{}
+	c.Segment.IndexIntervalBytes = *indexIntervalBytes
+	if len(keys) != 0 {
+		keyring, err := logpkg.NewKeyring(keys[0].id, keys.toMap())
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.Encryption.Keyring = keyring
+	}
+
+	var err error
+	switch cmd, args := flag.Arg(0), flag.Args()[1:]; cmd {
+	case "list":
+		err = list(c)
+	case "dump":
+		err = dump(c, args)
+	case "verify":
+		err = verify(c, args)
+	case "rebuild-index":
+		err = rebuildIndex(c, args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func list(c logpkg.Config) error {
+	infos, err := logpkg.InspectSegments(*storeDir, c)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-20s %-20s %-10s %-12s %-12s %s\n", "BASE", "NEXT", "RECORDS", "STORE", "INDEX", "DAMAGE")
+	for _, info := range infos {
+		var damage []string
+		if info.CorruptFrames != 0 {
+			damage = append(damage, fmt.Sprintf("%d corrupt frames", info.CorruptFrames))
+		}
+		if info.Err != nil {
+			damage = append(damage, info.Err.Error())
+		}
+		fmt.Printf(
+			"%-20d %-20d %-10d %-12d %-12d %s\n",
+			info.BaseOffset, info.NextOffset, info.Records, info.StoreBytes, info.IndexBytes, strings.Join(damage, ", "),
+		)
+	}
+
+	return nil
+}
+
+// Record is a record as dumped, in the JSON form of the http api.
+type Record struct {
+	Key       string    `json:"key,omitempty"`
+	Value     string    `json:"value"`
+	Offset    uint64    `json:"offset"`
+	Timestamp time.Time `json:"timestamp"`
+	Headers   []Header  `json:"headers,omitempty"`
+}
+
+type Header struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func dump(c logpkg.Config, args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	from := fs.Uint64("from", 0, "first offset to dump")
+	to := fs.Uint64("to", math.MaxUint64, "offset to stop the dump at, excluded")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	return logpkg.ReadRecords(*storeDir, c, *from, *to, func(record *api.Record) error {
+		r := Record{
+			Key:       string(record.Key),
+			Value:     string(record.Value),
+			Offset:    record.Offset,
+			Timestamp: time.Unix(0, record.Timestamp).UTC(),
+		}
+		for _, h := range record.Headers {
+			r.Headers = append(r.Headers, Header{Key: h.Key, Value: string(h.Value)})
+		}
+
+		return out.Encode(r)
+	})
+}
+
+func verify(c logpkg.Config, args []string) error {
+	var bases []uint64
+	if len(args) != 0 {
+		base, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid base offset %q: %w", args[0], err)
+		}
+		bases = append(bases, base)
+	} else {
+		infos, err := logpkg.InspectSegments(*storeDir, c)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			bases = append(bases, info.BaseOffset)
+		}
+	}
+
+	var failed int
+	for _, base := range bases {
+		problems, err := logpkg.VerifyIndex(*storeDir, c, base)
+		if err != nil {
+			return fmt.Errorf("segment %d: %w", base, err)
+		}
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) != 0 {
+			failed++
+		}
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("%d of %d segments have invalid index entries", failed, len(bases))
+	}
+	fmt.Printf("%d segments verified\n", len(bases))
+
+	return nil
+}
+
+func rebuildIndex(c logpkg.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("rebuild-index takes the base offset of the segment")
+	}
+	base, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid base offset %q: %w", args[0], err)
+	}
+
+	// a damaged store still gets the entries of the frames before the damage
+	n, err := logpkg.RebuildIndex(*storeDir, c, base)
+	if err == nil || n != 0 {
+		fmt.Printf("segment %d: %d index entries written\n", base, n)
+	}
+
+	return err
+}
+
+// key is an encryption key given on the command line.
+type key struct {
+	id    uint32
+	bytes []byte
+}
+
+// keyFlag collects the encryption keys given as id=hex. The first one is made current, although records are only
+// decrypted.
+type keyFlag []key
+
+func (f *keyFlag) String() string {
+	return fmt.Sprintf("%d keys", len(*f))
+}
+
+func (f *keyFlag) Set(v string) error {
+	id, p, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("expected id=hex")
+	}
+
+	n, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return err
+	}
+	b, err := hex.DecodeString(p)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, key{id: uint32(n), bytes: b})
+
+	return nil
+}
+
+func (f keyFlag) toMap() map[uint32][]byte {
+	m := make(map[uint32][]byte, len(f))
+	for _, k := range f {
+		m[k.id] = k.bytes
+	}
+
+	return m
+}
@@ -8,6 +8,7 @@ import (
 	"syscall"
 
 	"github.com/vlamug/pdlog/internal/agent"
+	logpkg "github.com/vlamug/pdlog/internal/log"
 	"go.uber.org/zap"
 )
 
@@ -23,6 +24,22 @@ var (
 	grpcAddr = flag.String("grpc_addr", defaultGRPCAddr, "addr to run grpc server on")
 	serfAddr = flag.String("serf_addr", defaultSerfAddr, "addr to run serf on")
 	storeDir = flag.String("dir", defaultStoreDir, "directory to store data into")
+
+	retentionMaxAge   = flag.Duration("retention_max_age", 0, "max age of closed segments, zero keeps them forever")
+	retentionMaxBytes = flag.Uint64("retention_max_bytes", 0, "max bytes the log may occupy, zero means no limit")
+	segmentMaxAge     = flag.Duration("segment_max_age", 0, "age at which the active segment is rolled, zero rolls by size only")
+
+	groupCommitWindow  = flag.Duration("group_commit_window", 0, "how long concurrent appends are collected to be committed together, zero disables it")
+	indexIntervalBytes = flag.Uint64("index_interval_bytes", 0, "store bytes between two index entries, zero indexes every record")
+	compaction         = flag.Bool("compaction", false, "keep only the newest record of every key in closed segments")
+	cacheMaxBytes      = flag.Uint64("cache_max_bytes", 0, "bytes of recent records kept in memory, zero disables the cache")
+
+	groupSessionTimeout = flag.Duration("group_session_timeout", 0, "session timeout of the consumer group members asking for none, zero means 10s")
+
+	topicPartitions = flag.Int("topic_partitions", 1, "partitions of the topics created without an explicit count")
+
+	tierDir           = flag.String("tier_dir", "", "directory closed segments are offloaded to, empty disables tiered storage")
+	tierLocalMaxBytes = flag.Uint64("tier_local_max_bytes", 0, "local bytes kept once segments are offloaded, zero keeps them all")
 )
 
 func main() {
@@ -38,6 +55,23 @@ func main() {
 		RPCBindAddr:  *grpcAddr,
 		SerfBindAddr: *serfAddr,
 	}
+	agentConfig.LogConfig.Retention.MaxAge = *retentionMaxAge
+	agentConfig.LogConfig.Retention.MaxBytes = *retentionMaxBytes
+	agentConfig.LogConfig.Segment.MaxAge = *segmentMaxAge
+	agentConfig.LogConfig.Segment.IndexIntervalBytes = *indexIntervalBytes
+	agentConfig.LogConfig.GroupCommit.Window = *groupCommitWindow
+	agentConfig.LogConfig.Compaction.Enabled = *compaction
+	agentConfig.LogConfig.Cache.MaxBytes = *cacheMaxBytes
+	agentConfig.LogConfig.Topic.Partitions = *topicPartitions
+	agentConfig.GroupConfig.SessionTimeout = *groupSessionTimeout
+	if *tierDir != "" {
+		store, err := logpkg.NewLocalObjectStore(*tierDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		agentConfig.LogConfig.Tiering.Store = store
+		agentConfig.LogConfig.Tiering.LocalMaxBytes = *tierLocalMaxBytes
+	}
 	a, err := agent.New(agentConfig)
 	if err != nil {
 		log.Fatal(err)
@@ -3,6 +3,7 @@ module github.com/vlamug/pdlog
 go 1.21.5
 
 require (
+	github.com/golang/snappy v0.0.4
 	github.com/gorilla/mux v1.8.0
 	github.com/hashicorp/serf v0.10.1
 	github.com/stretchr/testify v1.8.1
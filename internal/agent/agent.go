@@ -9,6 +9,7 @@ import (
 
 	"github.com/vlamug/pdlog/api/v1"
 	"github.com/vlamug/pdlog/internal/discovery"
+	"github.com/vlamug/pdlog/internal/group"
 	"github.com/vlamug/pdlog/internal/log"
 	"github.com/vlamug/pdlog/internal/server"
 	"go.uber.org/zap"
@@ -21,7 +22,7 @@ type (
 	Agent struct {
 		Config
 
-		log        *log.Log
+		logs       *log.LogManager
 		httpServer *http.Server
 		grpcServer *grpc.Server
 		membership *discovery.Membership
@@ -41,6 +42,10 @@ type (
 		StartJoinAddrs []string
 		ACLModelFile   string
 		ACLPolicyFile  string
+		LogConfig      log.Config
+		// TopicConfigs overrides LogConfig for the named topics.
+		TopicConfigs map[string]log.Config
+		GroupConfig  group.Config
 	}
 )
 
@@ -62,7 +67,7 @@ func New(config Config) (*Agent, error) {
 			return nil, err
 		}
 	}
-  
+
 	return a, nil
 }
 
@@ -79,15 +84,121 @@ func (a *Agent) setupLogger() error {
 
 func (a *Agent) setupLog() error {
 	var err error
-  
-	a.log, err = log.NewLog(a.Config.DataDir, log.Config{})
+
+	a.logs, err = log.NewLogManager(a.Config.DataDir, a.Config.LogConfig, a.Config.TopicConfigs)
 
 	return err
 }
 
+// topicManager hands the topics of the log manager and the offsets committed by the consumer groups to the server.
+type topicManager struct {
+	*log.LogManager
+}
+
+func (m topicManager) Topic(name string, create bool) (server.Topic, error) {
+	get := m.LogManager.Topic
+	if create {
+		get = m.EnsureTopic
+	}
+
+	t, err := get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return topic{t}, nil
+}
+
+func (m topicManager) CreateTopic(name string, partitions uint32) error {
+	_, err := m.LogManager.CreateTopic(name, int(partitions))
+	return err
+}
+
+func (m topicManager) Topics() ([]server.TopicInfo, error) {
+	topics, err := m.LogManager.Topics()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]server.TopicInfo, len(topics))
+	for i, t := range topics {
+		infos[i] = server.TopicInfo{Name: t.Name, Partitions: uint32(t.Partitions)}
+	}
+
+	return infos, nil
+}
+
+func (m topicManager) Partitions(name string) (int, error) {
+	t, err := m.LogManager.Topic(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.Partitions(), nil
+}
+
+func (m topicManager) CommitOffset(group, topic string, partition uint32, offset uint64) error {
+	return m.LogManager.CommitOffset(group, topic, int(partition), offset)
+}
+
+func (m topicManager) FetchOffset(group, topic string, partition uint32) (uint64, error) {
+	return m.LogManager.FetchOffset(group, topic, int(partition))
+}
+
+// topic hands the partitions of a topic to the server.
+type topic struct {
+	*log.Topic
+}
+
+func (t topic) Partition(p uint32) (server.CommitLog, error) {
+	l, err := t.Topic.Partition(int(p))
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (t topic) PartitionFor(record *api.Record) uint32 {
+	return uint32(t.Topic.PartitionFor(record.Key))
+}
+
+// groupCoordinator hands the group coordinator to the server.
+type groupCoordinator struct {
+	*group.Coordinator
+}
+
+func (c groupCoordinator) JoinGroup(name, memberID string, topics []string, strategy string, sessionTimeout time.Duration) (server.Membership, error) {
+	m, err := c.Join(name, memberID, topics, group.Strategy(strategy), sessionTimeout)
+	if err != nil {
+		return server.Membership{}, err
+	}
+
+	membership := server.Membership{MemberID: m.MemberID, Generation: m.Generation}
+	for _, a := range m.Assignment {
+		partitions := make([]uint32, len(a.Partitions))
+		for i, p := range a.Partitions {
+			partitions[i] = uint32(p)
+		}
+		membership.Assignment = append(membership.Assignment, server.TopicPartitions{Topic: a.Topic, Partitions: partitions})
+	}
+
+	return membership, nil
+}
+
+func (c groupCoordinator) LeaveGroup(name, memberID string) error {
+	return c.Leave(name, memberID)
+}
+
+func (c groupCoordinator) CommitOffset(name, memberID string, generation uint64, topic string, partition uint32, offset uint64) error {
+	return c.Coordinator.CommitOffset(name, memberID, generation, topic, int(partition), offset)
+}
+
 func (a *Agent) setupServer() error {
 	serverConfig := &server.Config{
-		CommitLog: a.log,
+		TopicManager:     topicManager{a.logs},
+		OffsetManager:    topicManager{a.logs},
+		GroupCoordinator: groupCoordinator{group.New(topicManager{a.logs}, a.logs, &a.Config.GroupConfig)},
 	}
 
 	if err := a.setupGRPC(serverConfig); err != nil {
@@ -138,16 +249,19 @@ func (a *Agent) setupGRPC(serverConfig *server.Config) error {
 
 func (a *Agent) setupMembership() error {
 	// TODO(threadedstream): add support for secure communication in future
-	conn, err := grpc.Dial(a.RPCBindAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	dialOptions := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	conn, err := grpc.Dial(a.RPCBindAddr, dialOptions...)
 	if err != nil {
 		return err
 	}
-  
+
 	client := api.NewLogClient(conn)
 	a.replicator = &log.Replicator{
+		DialOptions: dialOptions,
 		LocalServer: client,
+		Keyring:     a.Config.LogConfig.Encryption.Keyring,
 	}
-  
+
 	a.membership, err = discovery.New(a.replicator, &discovery.Config{
 		NodeName: a.NodeName,
 		BindAddr: a.SerfBindAddr,
@@ -157,10 +271,6 @@ func (a *Agent) setupMembership() error {
 		StartJoinAddrs: a.StartJoinAddrs,
 	})
 
-	if err := a.server.Serve(ln); err != nil {
-		_ = a.Shutdown()
-	}
-
 	return err
 }
 
@@ -175,14 +285,14 @@ func (a *Agent) Shutdown() error {
 	a.shutdown = true
 	close(a.shutdowns)
 
-	shutdown := []func() error{
+	shutdowns := []func() error{
 		a.membership.Leave,
 		a.replicator.Close,
 		func() error {
 			a.grpcServer.GracefulStop()
 			return nil
 		},
-		a.log.Close,
+		a.logs.Close,
 	}
 
 	for _, fn := range shutdowns {
@@ -190,6 +300,6 @@ func (a *Agent) Shutdown() error {
 			return err
 		}
 	}
-  
+
 	return nil
 }
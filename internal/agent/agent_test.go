@@ -34,20 +34,21 @@ func TestAgent(t *testing.T) {
 	var agents []*Agent
 	for i := 0; i < 3; i++ {
 		bindAddr := fmt.Sprintf("%s:%d", "127.0.0.1", getPort())
+		rpcAddr := fmt.Sprintf("%s:%d", "127.0.0.1", getPort())
 
 		dataDir, err := os.MkdirTemp("", "agent-test-log")
 		require.NoError(t, err)
 
 		var startJoinAddrs []string
 		if i != 0 {
-			startJoinAddrs = append(startJoinAddrs, agents[0].cfg.BindAddr)
+			startJoinAddrs = append(startJoinAddrs, agents[0].Config.SerfBindAddr)
 		}
 
-		agent, err := New(&Config{
+		agent, err := New(Config{
 			NodeName:       fmt.Sprintf("node_%d", i),
 			StartJoinAddrs: startJoinAddrs,
-			BindAddr:       bindAddr,
-			RPCPort:        getPort(),
+			SerfBindAddr:   bindAddr,
+			RPCBindAddr:    rpcAddr,
 			DataDir:        dataDir,
 		})
 		require.NoError(t, err)
@@ -87,16 +88,14 @@ func TestAgent(t *testing.T) {
 	for _, agent := range agents {
 		err := agent.Shutdown()
 		require.NoError(t, err)
-		require.NoError(t, os.RemoveAll(agent.cfg.DataDir))
+		require.NoError(t, os.RemoveAll(agent.Config.DataDir))
 	}
 }
 
 func client(t *testing.T, agent *Agent) api.LogClient {
-	addr, err := agent.cfg.RPCAddr()
+	conn, err := grpc.Dial(agent.Config.RPCBindAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	require.NoError(t, err)
 
-	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
-
 	cl := api.NewLogClient(conn)
 
 	return cl
@@ -0,0 +1,88 @@
+package group
+
+import (
+	"slices"
+)
+
+// Strategy tells how the partitions of the topics are assigned to the members of a group.
+type Strategy string
+
+const (
+	// Range assigns every member a contiguous range of the partitions of each topic it subscribes to, the first
+	// members getting one more partition when they cannot be shared evenly.
+	Range Strategy = "range"
+	// RoundRobin deals the partitions of all the topics to the members in turn, skipping the members not subscribed to
+	// the topic of the partition. Members subscribed to the same topics get numbers of partitions differing by one at
+	// most, even when no topic has enough partitions for all of them.
+	RoundRobin Strategy = "roundrobin"
+)
+
+// Assignment lists the partitions of a topic assigned to a member.
+type Assignment struct {
+	Topic      string
+	Partitions []int
+}
+
+// assign assigns the partitions of the topics to the members, sorted by id, according to the strategy. partitions holds
+// the number of partitions of every topic the members subscribe to. It returns the assignment of every member, the
+// topics in lexical order and the partitions in ascending order.
+func assign(strategy Strategy, members []*member, partitions map[string]int) map[string][]Assignment {
+	assigned := make(map[string]map[string][]int, len(members))
+	for _, m := range members {
+		assigned[m.id] = make(map[string][]int)
+	}
+
+	topics := make([]string, 0, len(partitions))
+	for topic := range partitions {
+		topics = append(topics, topic)
+	}
+	slices.Sort(topics)
+
+	switch strategy {
+	case RoundRobin:
+		next := 0
+		for _, topic := range topics {
+			for p := 0; p < partitions[topic]; p++ {
+				// the next member subscribed to the topic, there is at least the one the topic comes from
+				for !slices.Contains(members[next%len(members)].topics, topic) {
+					next++
+				}
+				m := members[next%len(members)]
+				assigned[m.id][topic] = append(assigned[m.id][topic], p)
+				next++
+			}
+		}
+	default:
+		for _, topic := range topics {
+			var subscribed []*member
+			for _, m := range members {
+				if slices.Contains(m.topics, topic) {
+					subscribed = append(subscribed, m)
+				}
+			}
+
+			n, k := partitions[topic], len(subscribed)
+			for i, m := range subscribed {
+				from := i*(n/k) + min(i, n%k)
+				to := from + n/k
+				if i < n%k {
+					to++
+				}
+				for p := from; p < to; p++ {
+					assigned[m.id][topic] = append(assigned[m.id][topic], p)
+				}
+			}
+		}
+	}
+
+	assignments := make(map[string][]Assignment, len(members))
+	for _, m := range members {
+		for _, topic := range topics {
+			if ps := assigned[m.id][topic]; len(ps) != 0 {
+				assignments[m.id] = append(assignments[m.id], Assignment{Topic: topic, Partitions: ps})
+			}
+		}
+	}
+
+	return assignments
+}
@@ -0,0 +1,42 @@
+package group
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssign(t *testing.T) {
+	members := []*member{
+		{id: "a", topics: []string{"orders", "users"}},
+		{id: "b", topics: []string{"orders", "users"}},
+		{id: "c", topics: []string{"orders"}},
+	}
+	partitions := map[string]int{"orders": 5, "users": 3}
+
+	for scenario, tc := range map[string]struct {
+		strategy Strategy
+		want     map[string][]Assignment
+	}{
+		"range": {
+			strategy: Range,
+			want: map[string][]Assignment{
+				"a": {{"orders", []int{0, 1}}, {"users", []int{0, 1}}},
+				"b": {{"orders", []int{2, 3}}, {"users", []int{2}}},
+				"c": {{"orders", []int{4}}},
+			},
+		},
+		"round robin": {
+			strategy: RoundRobin,
+			want: map[string][]Assignment{
+				"a": {{"orders", []int{0, 3}}, {"users", []int{0, 2}}},
+				"b": {{"orders", []int{1, 4}}, {"users", []int{1}}},
+				"c": {{"orders", []int{2}}},
+			},
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			require.Equal(t, tc.want, assign(tc.strategy, members, partitions))
+		})
+	}
+}
@@ -0,0 +1,12 @@
+package group
+
+import "time"
+
+type Config struct {
+	// SessionTimeout is how long a member stays in its group without heartbeat when it does not ask for another
+	// timeout on joining.
+	SessionTimeout time.Duration
+	// MinSessionTimeout and MaxSessionTimeout bound the session timeouts the members may ask for.
+	MinSessionTimeout time.Duration
+	MaxSessionTimeout time.Duration
+}
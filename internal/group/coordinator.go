@@ -0,0 +1,351 @@
+package group
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vlamug/pdlog/api/v1"
+	"github.com/vlamug/pdlog/internal/log"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSessionTimeout    = 10 * time.Second
+	defaultMinSessionTimeout = time.Second
+	defaultMaxSessionTimeout = 5 * time.Minute
+)
+
+// Topics gives the number of partitions of the topics the members subscribe to.
+type Topics interface {
+	Partitions(topic string) (int, error)
+}
+
+// Offsets stores the offsets committed by the members.
+type Offsets interface {
+	CommitOffset(group, topic string, partition int, offset uint64) error
+}
+
+// Membership is what a member gets on joining its group: its id, the generation of the group and the partitions
+// assigned to the member in this generation.
+type Membership struct {
+	MemberID   string
+	Generation uint64
+	Assignment []Assignment
+}
+
+// Coordinator shares the partitions of the topics among the members of the consumer groups. Every change of the
+// members of a group, a member joining, leaving, changing its topics or missing its heartbeats for longer than its
+// session timeout, rebalances the group: the partitions are assigned again and the generation of the group is bumped.
+// The other members learn about it on their next heartbeat, which fails with ErrIllegalGeneration, and join again to
+// get their new assignment. Meanwhile, their offset commits are rejected, so a member cannot commit the offset of a
+// partition it lost.
+//
+// The groups are kept in memory only, the members join again after a restart. Expired members are removed when their
+// group is next used.
+type Coordinator struct {
+	cfg     *Config
+	topics  Topics
+	offsets Offsets
+
+	mu     sync.Mutex
+	groups map[string]*group
+	now    func() time.Time
+
+	logger *zap.Logger
+}
+
+type group struct {
+	name       string
+	generation uint64
+	strategy   Strategy
+	members    map[string]*member
+}
+
+type member struct {
+	id string
+	// topics are the subscribed topics in lexical order
+	topics         []string
+	sessionTimeout time.Duration
+	deadline       time.Time
+	assignment     []Assignment
+}
+
+func New(topics Topics, offsets Offsets, cfg *Config) *Coordinator {
+	if cfg.SessionTimeout == 0 {
+		cfg.SessionTimeout = defaultSessionTimeout
+	}
+	if cfg.MinSessionTimeout == 0 {
+		cfg.MinSessionTimeout = defaultMinSessionTimeout
+	}
+	if cfg.MaxSessionTimeout == 0 {
+		cfg.MaxSessionTimeout = defaultMaxSessionTimeout
+	}
+
+	return &Coordinator{
+		cfg:     cfg,
+		topics:  topics,
+		offsets: offsets,
+		groups:  make(map[string]*group),
+		now:     time.Now,
+		logger:  zap.L().Named("group_coordinator"),
+	}
+}
+
+// Join adds a member subscribed to the topics to the group and returns its membership. A new member joins without id
+// and gets one, a member joins again with its id to get its assignment once the group was rebalanced. The strategy,
+// Range when empty, must be the one of the other members. A zero session timeout means the configured one.
+func (c *Coordinator) Join(name, memberID string, topics []string, strategy Strategy, sessionTimeout time.Duration) (Membership, error) {
+	if err := log.ValidateGroup(name); err != nil {
+		return Membership{}, err
+	}
+
+	if strategy == "" {
+		strategy = Range
+	}
+	if strategy != Range && strategy != RoundRobin {
+		return Membership{}, api.ErrInvalidGroup{Group: name, Reason: fmt.Sprintf("unknown strategy %q", strategy)}
+	}
+
+	if sessionTimeout == 0 {
+		sessionTimeout = c.cfg.SessionTimeout
+	}
+	if sessionTimeout < c.cfg.MinSessionTimeout || sessionTimeout > c.cfg.MaxSessionTimeout {
+		return Membership{}, api.ErrInvalidGroup{
+			Group:  name,
+			Reason: fmt.Sprintf("session timeout %s not in [%s, %s]", sessionTimeout, c.cfg.MinSessionTimeout, c.cfg.MaxSessionTimeout),
+		}
+	}
+
+	topics = slices.Clone(topics)
+	slices.Sort(topics)
+	topics = slices.Compact(topics)
+	if len(topics) == 0 {
+		return Membership{}, api.ErrInvalidGroup{Group: name, Reason: "no topics"}
+	}
+	// the topics must exist, the ones deleted later are no longer assigned
+	for _, topic := range topics {
+		if _, err := c.topics.Partitions(topic); err != nil {
+			return Membership{}, err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	g := c.group(name, now)
+	if g == nil {
+		if memberID != "" {
+			return Membership{}, api.ErrUnknownMember{Group: name, MemberID: memberID}
+		}
+		g = &group{name: name, strategy: strategy, members: make(map[string]*member)}
+		c.groups[name] = g
+	}
+
+	m, ok := g.members[memberID]
+	if memberID != "" && !ok {
+		return Membership{}, api.ErrUnknownMember{Group: name, MemberID: memberID}
+	}
+
+	others := len(g.members)
+	if ok {
+		others--
+	}
+	if others != 0 && strategy != g.strategy {
+		return Membership{}, api.ErrInvalidGroup{
+			Group:  name,
+			Reason: fmt.Sprintf("strategy %s differs from the %s strategy of the group", strategy, g.strategy),
+		}
+	}
+
+	changed := !ok || strategy != g.strategy || !slices.Equal(m.topics, topics)
+	if !ok {
+		id, err := newMemberID()
+		if err != nil {
+			return Membership{}, err
+		}
+		m = &member{id: id}
+		g.members[m.id] = m
+	}
+
+	m.topics = topics
+	m.sessionTimeout = sessionTimeout
+	m.deadline = now.Add(sessionTimeout)
+	g.strategy = strategy
+	if changed {
+		c.rebalance(g)
+	}
+
+	return Membership{MemberID: m.id, Generation: g.generation, Assignment: m.assignment}, nil
+}
+
+// Heartbeat keeps the member in its group for another session timeout. It fails with ErrIllegalGeneration when the
+// group was rebalanced since the generation, the member then has to join again.
+func (c *Coordinator) Heartbeat(name, memberID string, generation uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	g, m, err := c.member(name, memberID, now)
+	if err != nil {
+		return err
+	}
+
+	// the member is kept while it joins again
+	m.deadline = now.Add(m.sessionTimeout)
+
+	return g.checkGeneration(generation)
+}
+
+// Leave removes the member from its group, whose partitions are assigned to the other members.
+func (c *Coordinator) Leave(name, memberID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	g, _, err := c.member(name, memberID, c.now())
+	if err != nil {
+		return err
+	}
+
+	delete(g.members, memberID)
+	c.logger.Info("member left group", zap.String("group", name), zap.String("member", memberID))
+	c.removed(g)
+
+	return nil
+}
+
+// CommitOffset commits the offset of the partition for the member of the group in the generation. A group without
+// members, i.e. consumers committing offsets without joining, commits without member id.
+func (c *Coordinator) CommitOffset(name, memberID string, generation uint64, topic string, partition int, offset uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the lock is held until committed, so the group cannot be rebalanced in between
+	if memberID == "" {
+		if g := c.group(name, c.now()); g != nil {
+			return api.ErrUnknownMember{Group: name}
+		}
+		return c.offsets.CommitOffset(name, topic, partition, offset)
+	}
+
+	g, _, err := c.member(name, memberID, c.now())
+	if err != nil {
+		return err
+	}
+	if err = g.checkGeneration(generation); err != nil {
+		return err
+	}
+
+	return c.offsets.CommitOffset(name, topic, partition, offset)
+}
+
+// group returns the group after removing its expired members, nil when it has no members. The caller must hold the
+// lock.
+func (c *Coordinator) group(name string, now time.Time) *group {
+	g, ok := c.groups[name]
+	if !ok {
+		return nil
+	}
+
+	expired := false
+	for id, m := range g.members {
+		if now.After(m.deadline) {
+			delete(g.members, id)
+			expired = true
+			c.logger.Info("member session expired", zap.String("group", name), zap.String("member", id))
+		}
+	}
+	if expired {
+		c.removed(g)
+	}
+
+	return c.groups[name]
+}
+
+// member returns the group and its member. The caller must hold the lock.
+func (c *Coordinator) member(name, memberID string, now time.Time) (*group, *member, error) {
+	g := c.group(name, now)
+	if g == nil {
+		return nil, nil, api.ErrUnknownMember{Group: name, MemberID: memberID}
+	}
+
+	m, ok := g.members[memberID]
+	if !ok {
+		return nil, nil, api.ErrUnknownMember{Group: name, MemberID: memberID}
+	}
+
+	return g, m, nil
+}
+
+// removed rebalances the group once members were removed, or forgets it when none is left. The caller must hold the
+// lock.
+func (c *Coordinator) removed(g *group) {
+	if len(g.members) == 0 {
+		delete(c.groups, g.name)
+		return
+	}
+
+	c.rebalance(g)
+}
+
+// rebalance assigns the partitions to the members and bumps the generation of the group. The caller must hold the
+// lock.
+func (c *Coordinator) rebalance(g *group) {
+	members := make([]*member, 0, len(g.members))
+	partitions := make(map[string]int)
+	for _, m := range g.members {
+		members = append(members, m)
+		for _, topic := range m.topics {
+			partitions[topic] = 0
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].id < members[j].id
+	})
+
+	for topic := range partitions {
+		n, err := c.topics.Partitions(topic)
+		if err != nil {
+			// the topic was deleted since the member joined
+			c.logger.Warn("topic not assigned", zap.String("group", g.name), zap.String("topic", topic), zap.Error(err))
+			continue
+		}
+		partitions[topic] = n
+	}
+
+	assignments := assign(g.strategy, members, partitions)
+	for _, m := range members {
+		m.assignment = assignments[m.id]
+	}
+	g.generation++
+
+	c.logger.Info(
+		"rebalanced group",
+		zap.String("group", g.name),
+		zap.Uint64("generation", g.generation),
+		zap.Int("members", len(members)),
+	)
+}
+
+// checkGeneration checks that the generation is the current one of the group.
+func (g *group) checkGeneration(generation uint64) error {
+	if generation != g.generation {
+		return api.ErrIllegalGeneration{Group: g.name, Generation: generation, Current: g.generation}
+	}
+
+	return nil
+}
+
+func newMemberID() (string, error) {
+	p := make([]byte, 16)
+	if _, err := rand.Read(p); err != nil {
+		return "", fmt.Errorf("generate member id: %w", err)
+	}
+
+	return hex.EncodeToString(p), nil
+}
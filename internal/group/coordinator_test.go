@@ -0,0 +1,109 @@
+package group
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vlamug/pdlog/api/v1"
+)
+
+func TestCoordinator(t *testing.T) {
+	offsets := &offsetsMock{}
+	c := New(topicsMock{"orders": 4, "users": 2}, offsets, &Config{})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	a, err := c.Join("billing", "", []string{"orders"}, Range, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), a.Generation)
+	require.Equal(t, []Assignment{{"orders", []int{0, 1, 2, 3}}}, a.Assignment)
+	require.NoError(t, c.CommitOffset("billing", a.MemberID, a.Generation, "orders", 0, 10))
+
+	// a new member rebalances the group, the first one learns about it on its heartbeat and joins again
+	b, err := c.Join("billing", "", []string{"orders"}, Range, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), b.Generation)
+	require.Len(t, b.Assignment[0].Partitions, 2)
+
+	require.Equal(t, api.ErrIllegalGeneration{Group: "billing", Generation: 1, Current: 2}, c.Heartbeat("billing", a.MemberID, 1))
+	require.Equal(t, api.ErrIllegalGeneration{Group: "billing", Generation: 1, Current: 2}, c.CommitOffset("billing", a.MemberID, 1, "orders", 0, 11))
+
+	a, err = c.Join("billing", a.MemberID, []string{"orders"}, Range, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), a.Generation)
+	require.ElementsMatch(t, []int{0, 1, 2, 3}, append(a.Assignment[0].Partitions, b.Assignment[0].Partitions...))
+	require.NoError(t, c.Heartbeat("billing", a.MemberID, 2))
+	require.NoError(t, c.CommitOffset("billing", a.MemberID, 2, "orders", a.Assignment[0].Partitions[0], 11))
+
+	// the members of a group use the same strategy
+	_, err = c.Join("billing", "", []string{"orders"}, RoundRobin, 0)
+	require.IsType(t, api.ErrInvalidGroup{}, err)
+
+	// a member missing its heartbeats is removed, its partitions go to the others
+	now = now.Add(5 * time.Second)
+	require.NoError(t, c.Heartbeat("billing", b.MemberID, 2))
+	now = now.Add(6 * time.Second)
+	require.IsType(t, api.ErrIllegalGeneration{}, c.Heartbeat("billing", b.MemberID, 2))
+	require.Equal(t, api.ErrUnknownMember{Group: "billing", MemberID: a.MemberID}, c.Heartbeat("billing", a.MemberID, 3))
+	b, err = c.Join("billing", b.MemberID, []string{"orders"}, Range, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), b.Generation)
+	require.Equal(t, []Assignment{{"orders", []int{0, 1, 2, 3}}}, b.Assignment)
+
+	// committing without joining is only for groups without members
+	require.Equal(t, api.ErrUnknownMember{Group: "billing"}, c.CommitOffset("billing", "", 0, "orders", 0, 12))
+	require.NoError(t, c.Leave("billing", b.MemberID))
+	require.NoError(t, c.CommitOffset("billing", "", 0, "orders", 0, 12))
+	require.Equal(t, api.ErrUnknownMember{Group: "billing", MemberID: b.MemberID}, c.Leave("billing", b.MemberID))
+
+	require.Equal(t, []commit{
+		{"billing", "orders", 0, 10},
+		{"billing", "orders", a.Assignment[0].Partitions[0], 11},
+		{"billing", "orders", 0, 12},
+	}, offsets.commits)
+
+	for _, tc := range []struct {
+		group    string
+		topics   []string
+		strategy Strategy
+		timeout  time.Duration
+	}{
+		{"", []string{"orders"}, Range, 0},
+		{"billing", nil, Range, 0},
+		{"billing", []string{"orders"}, "sticky", 0},
+		{"billing", []string{"orders"}, Range, time.Hour},
+	} {
+		_, err = c.Join(tc.group, "", tc.topics, tc.strategy, tc.timeout)
+		require.IsType(t, api.ErrInvalidGroup{}, err)
+	}
+	_, err = c.Join("billing", "", []string{"payments"}, Range, 0)
+	require.Equal(t, api.ErrTopicNotFound{Topic: "payments"}, err)
+}
+
+type topicsMock map[string]int
+
+func (m topicsMock) Partitions(topic string) (int, error) {
+	n, ok := m[topic]
+	if !ok {
+		return 0, api.ErrTopicNotFound{Topic: topic}
+	}
+
+	return n, nil
+}
+
+type commit struct {
+	group     string
+	topic     string
+	partition int
+	offset    uint64
+}
+
+type offsetsMock struct {
+	commits []commit
+}
+
+func (m *offsetsMock) CommitOffset(group, topic string, partition int, offset uint64) error {
+	m.commits = append(m.commits, commit{group, topic, partition, offset})
+	return nil
+}
@@ -0,0 +1,132 @@
+package log
+
+import (
+	"container/list"
+	"sync"
+
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// recordCache keeps the records most recently appended or read, up to a number of bytes, evicting the least recently
+// used ones first. The cached records are shared with the readers, so they must not be modified. A nil cache caches
+// nothing.
+type recordCache struct {
+	mu       sync.Mutex
+	maxBytes uint64
+	size     uint64
+	entries  map[uint64]*list.Element
+	lru      *list.List
+	// cleared counts the times the cache was cleared, so a record read before is not cached after
+	cleared uint64
+}
+
+type cacheEntry struct {
+	record *log_v1.Record
+	size   uint64
+}
+
+func newRecordCache(maxBytes uint64) *recordCache {
+	return &recordCache{
+		maxBytes: maxBytes,
+		entries:  make(map[uint64]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// get returns the record stored under the offset if it is cached.
+func (c *recordCache) get(off uint64) (*log_v1.Record, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[off]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+
+	return e.Value.(*cacheEntry).record, true
+}
+
+// generation returns the number of times the cache was cleared, to be passed to addRead.
+func (c *recordCache) generation() uint64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.cleared
+}
+
+// add caches the record under its offset. Records bigger than the cache are not cached.
+func (c *recordCache) add(record *log_v1.Record) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.put(record)
+}
+
+// addRead caches a record read from the segments, unless the cache was cleared since the generation was taken: the
+// record may then come from a segment replaced in the meantime.
+func (c *recordCache) addRead(generation uint64, record *log_v1.Record) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cleared == generation {
+		c.put(record)
+	}
+}
+
+// put caches the record unless it is bigger than the cache. The caller must hold the lock.
+func (c *recordCache) put(record *log_v1.Record) {
+	size := uint64(proto.Size(record))
+	if size > c.maxBytes {
+		return
+	}
+
+	if e, ok := c.entries[record.Offset]; ok {
+		c.remove(e)
+	}
+
+	c.entries[record.Offset] = c.lru.PushFront(&cacheEntry{record: record, size: size})
+	c.size += size
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops the entry from the cache. The caller must hold the lock.
+func (c *recordCache) remove(e *list.Element) {
+	entry := c.lru.Remove(e).(*cacheEntry)
+	delete(c.entries, entry.record.Offset)
+	c.size -= entry.size
+}
+
+// clear drops all the cached records.
+func (c *recordCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+	c.lru.Init()
+	c.size = 0
+	c.cleared++
+}
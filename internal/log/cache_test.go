@@ -0,0 +1,103 @@
+package log
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRecordCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	record := func(off uint64) *log_v1.Record {
+		return &log_v1.Record{Value: []byte("hello world"), Offset: off}
+	}
+	size := uint64(proto.Size(record(1)))
+
+	c := newRecordCache(3 * size)
+	for off := uint64(1); off <= 3; off++ {
+		c.add(record(off))
+	}
+
+	// reading the first record makes the second one the least recently used
+	_, ok := c.get(1)
+	require.True(t, ok)
+
+	c.add(record(4))
+	_, ok = c.get(2)
+	require.False(t, ok)
+	for _, off := range []uint64{1, 3, 4} {
+		got, ok := c.get(off)
+		require.True(t, ok)
+		require.Equal(t, off, got.Offset)
+	}
+	require.Equal(t, 3*size, c.size)
+
+	c.add(&log_v1.Record{Value: make([]byte, 4*size)})
+	require.Len(t, c.entries, 3)
+
+	c.clear()
+	_, ok = c.get(1)
+	require.False(t, ok)
+	require.Zero(t, c.size)
+}
+
+func TestRecordCacheSkipsStaleReads(t *testing.T) {
+	c := newRecordCache(1024)
+	record := &log_v1.Record{Value: []byte("hello world"), Offset: 1}
+
+	// a record read before the cache was cleared may come from a replaced segment
+	generation := c.generation()
+	c.clear()
+	c.addRead(generation, record)
+	_, ok := c.get(1)
+	require.False(t, ok)
+
+	c.addRead(c.generation(), record)
+	_, ok = c.get(1)
+	require.True(t, ok)
+}
+
+func TestLogCache(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 2
+	c.Cache.MaxBytes = 1024
+	log := newTestLog(t, c)
+	defer log.Remove()
+
+	record := &log_v1.Record{Value: []byte("hello world")}
+	for i := 0; i < 4; i++ {
+		_, err := log.Append(record)
+		require.NoError(t, err)
+	}
+
+	// appended records are cached as they were when appended, even if the caller reuses them
+	got, err := log.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), got.Offset)
+	require.Equal(t, Stats{CacheHits: 1}, cacheStats(log))
+
+	log.cache.clear()
+	_, err = log.Read(1)
+	require.NoError(t, err)
+	_, err = log.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, Stats{CacheHits: 2, CacheMisses: 1}, cacheStats(log))
+
+	require.NoError(t, log.Truncate(1))
+	require.Empty(t, log.cache.entries)
+	_, err = log.Read(1)
+	require.Error(t, err)
+
+	_, err = log.Read(2)
+	require.NoError(t, err)
+	require.NoError(t, log.Reset())
+	require.Empty(t, log.cache.entries)
+	_, err = log.Read(2)
+	require.Error(t, err)
+}
+
+func cacheStats(log *Log) Stats {
+	stats := log.Stats()
+	return Stats{CacheHits: stats.CacheHits, CacheMisses: stats.CacheMisses}
+}
@@ -0,0 +1,75 @@
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/golang/snappy"
+)
+
+// Codec is the compression applied to the records before they are written to the store, the records appended in a
+// batch being compressed together. The codec is kept in the attributes of every frame, so a segment written with
+// different codecs stays readable.
+type Codec byte
+
+const (
+	CodecNone Codec = iota
+	CodecGzip
+	CodecSnappy
+)
+
+func (c Codec) String() string {
+	switch c {
+	case CodecNone:
+		return "none"
+	case CodecGzip:
+		return "gzip"
+	case CodecSnappy:
+		return "snappy"
+	default:
+		return fmt.Sprintf("codec(%d)", byte(c))
+	}
+}
+
+func (c Codec) compress(p []byte) ([]byte, error) {
+	switch c {
+	case CodecNone:
+		return p, nil
+	case CodecGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(p); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	case CodecSnappy:
+		return snappy.Encode(nil, p), nil
+	default:
+		return nil, fmt.Errorf("unknown %s", c)
+	}
+}
+
+func (c Codec) decompress(p []byte) ([]byte, error) {
+	switch c {
+	case CodecNone:
+		return p, nil
+	case CodecGzip:
+		r, err := gzip.NewReader(bytes.NewReader(p))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		return io.ReadAll(r)
+	case CodecSnappy:
+		return snappy.Decode(nil, p)
+	default:
+		return nil, fmt.Errorf("unknown %s", c)
+	}
+}
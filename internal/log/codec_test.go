@@ -0,0 +1,26 @@
+package log
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodec(t *testing.T) {
+	p := bytes.Repeat([]byte(`{"name": "hello world"}`), 16)
+
+	for _, codec := range []Codec{CodecNone, CodecGzip, CodecSnappy} {
+		t.Run(codec.String(), func(t *testing.T) {
+			compressed, err := codec.compress(p)
+			require.NoError(t, err)
+			if codec != CodecNone {
+				require.Less(t, len(compressed), len(p))
+			}
+
+			decompressed, err := codec.decompress(compressed)
+			require.NoError(t, err)
+			require.Equal(t, p, decompressed)
+		})
+	}
+}
@@ -0,0 +1,334 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path"
+	"slices"
+	"time"
+
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultCompactionInterval = time.Minute
+	defaultTombstoneRetention = 24 * time.Hour
+)
+
+const (
+	// cleanedDir holds the segment being rewritten by compaction until it is complete.
+	cleanedDir = ".cleaned"
+	// swapDir holds a complete rewritten segment while its files replace the original ones.
+	swapDir = ".swap"
+)
+
+// CompactedSegment describes a segment rewritten by compaction.
+type CompactedSegment struct {
+	BaseOffset uint64
+	NextOffset uint64
+	// Kept and Dropped are the numbers of records the segment kept and lost.
+	Kept    uint64
+	Dropped uint64
+	// Bytes and CompactedBytes are the disk space the segment occupied before and after compaction.
+	Bytes          uint64
+	CompactedBytes uint64
+}
+
+// Compact rewrites the closed segments keeping only the newest record of every key, records without a key are always
+// kept. Tombstones, i.e. keyed records without a value, are dropped once they are older than the tombstone retention.
+// Records keep their offsets, so consumers see gaps where records were dropped. The active segment is neither
+// compacted nor locked while the closed segments are rewritten, only the swap of a rewritten segment blocks the log.
+func (l *Log) Compact(now time.Time) ([]CompactedSegment, error) {
+	l.maintenance.Lock()
+	defer l.maintenance.Unlock()
+
+	segments := l.segments()
+	closed := segments[:len(segments)-1]
+
+	// only the closed segments are looked at, so a key updated in the active segment keeps its previous record for now
+	latest := make(map[string]uint64)
+	for _, seg := range closed {
+		err := seg.records(func(record *log_v1.Record) error {
+			if len(record.Key) != 0 {
+				latest[string(record.Key)] = record.Offset
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var compacted []CompactedSegment
+	for _, seg := range closed {
+		info, err := l.compactSegment(seg, latest, now)
+		if err != nil {
+			return compacted, err
+		}
+
+		if info.Dropped != 0 {
+			compacted = append(compacted, info)
+		}
+	}
+
+	return compacted, nil
+}
+
+// compactSegment rewrites the segment without the records superseded by the latest ones and swaps it in. The segment
+// is left as is when no record has to be dropped. The caller must hold the maintenance lock.
+func (l *Log) compactSegment(seg *segment, latest map[string]uint64, now time.Time) (CompactedSegment, error) {
+	info := CompactedSegment{
+		BaseOffset: seg.baseOffset,
+		NextOffset: seg.nextOffset.Load(),
+		Bytes:      seg.size(),
+	}
+
+	size, err := l.rewriteSegment(seg, func(f frame, records []*log_v1.Record) (frame, []*log_v1.Record, error) {
+		var kept []*log_v1.Record
+		for _, record := range records {
+			if !l.keep(record, latest, now) {
+				info.Dropped++
+				continue
+			}
+
+			info.Kept++
+			kept = append(kept, record)
+		}
+
+		return f, kept, nil
+	})
+	info.CompactedBytes = size
+
+	return info, err
+}
+
+// rewriteSegment writes the records of the segment to a new segment, which is swapped in when it differs from the
+// original one. fn returns the frame to store for every frame along with the records it keeps out of the frame ones,
+// records keep their offsets. The records left of a batch frame are encoded again, as the frame does not hold them
+// alone. It returns the size of the new segment. The caller must hold the maintenance lock.
+func (l *Log) rewriteSegment(seg *segment, fn func(f frame, records []*log_v1.Record) (frame, []*log_v1.Record, error)) (uint64, error) {
+	dir := path.Join(l.Dir, cleanedDir)
+	if err := os.RemoveAll(dir); err != nil {
+		return 0, err
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return 0, err
+	}
+
+	cleaned, err := newSegment(dir, seg.baseOffset, l.Config)
+	if err != nil {
+		return 0, err
+	}
+
+	var changed bool
+	err = seg.frames(func(f frame, records []*log_v1.Record) error {
+		out, kept, err := fn(f, records)
+		switch {
+		case err != nil:
+			return err
+		case len(kept) == 0:
+			changed = true
+			return nil
+		case len(kept) < len(records):
+			changed = true
+			return cleaned.writeRecords(kept)
+		}
+
+		changed = changed || out.attrs != f.attrs || !bytes.Equal(out.payload, f.payload)
+		return cleaned.writeFrame(out.payload, out.attrs, records)
+	})
+	size := cleaned.size()
+
+	if cerr := cleaned.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || !changed {
+		if rerr := os.RemoveAll(dir); err == nil {
+			err = rerr
+		}
+		return size, err
+	}
+
+	// the offloaded copy is stale once the segment is rewritten, it is deleted beforehand so a crash cannot leave it
+	// behind, and the rewritten segment is offloaded again
+	if l.tier != nil {
+		if err = l.tier.remove(seg.baseOffset); err != nil {
+			if rerr := os.RemoveAll(dir); rerr != nil {
+				err = errors.Join(err, rerr)
+			}
+			return size, err
+		}
+	}
+
+	// renaming the directory commits the rewritten segment, an interrupted swap is completed on setup
+	if err = os.Rename(dir, path.Join(l.Dir, swapDir)); err != nil {
+		return size, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return size, l.swap(seg)
+}
+
+// keep tells whether compaction keeps the record.
+func (l *Log) keep(record *log_v1.Record, latest map[string]uint64, now time.Time) bool {
+	if len(record.Key) == 0 {
+		return true
+	}
+
+	if latest[string(record.Key)] != record.Offset {
+		return false
+	}
+
+	// tombstones are kept for a while, so consumers get to see the deletion
+	return len(record.Value) != 0 || now.Sub(time.Unix(0, record.Timestamp)) <= l.Config.Compaction.TombstoneRetention
+}
+
+// swap replaces the segment with the rewritten one waiting in the swap directory. The caller must hold the lock.
+func (l *Log) swap(seg *segment) error {
+	// the original segment keeps reading its files once they are replaced, until the readers holding it are done
+	if err := finishSwap(l.Dir); err != nil {
+		return err
+	}
+
+	s, err := newSegment(l.Dir, seg.baseOffset, l.Config)
+	if err != nil {
+		return err
+	}
+	// the last records of the segment may be gone, but its range of offsets stays the same
+	s.nextOffset.Store(seg.nextOffset.Load())
+
+	segments := slices.Clone(l.segments())
+	i := slices.Index(segments, seg)
+	segments[i] = s
+
+	// a segment left without records is of no use, unless it is the first one telling the lowest offset of the log
+	empty := i > 0 && s.index.size.Load() == 0
+	if empty {
+		segments = slices.Delete(segments, i, i+1)
+	}
+	l.publish(segments)
+	// the cache may still hold the records compacted away, the ones read from the original segment until now included
+	l.cache.clear()
+
+	if err = seg.Close(); err != nil {
+		return err
+	}
+	if empty {
+		return s.Remove()
+	}
+
+	return nil
+}
+
+// finishSwap moves the files of the rewritten segment from the swap directory over the original ones. The segments of
+// a restored snapshot, marked by the replace file, replace all the segments of the log instead.
+func finishSwap(dir string) error {
+	swap := path.Join(dir, swapDir)
+	files, err := os.ReadDir(swap)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// the marker is removed once the original segments are, so an interrupted swap never removes the restored ones
+	if i := slices.IndexFunc(files, func(file os.DirEntry) bool { return file.Name() == replaceFile }); i >= 0 {
+		if err = removeSegmentFiles(dir); err != nil {
+			return err
+		}
+		if err = os.Remove(path.Join(swap, replaceFile)); err != nil {
+			return err
+		}
+		files = slices.Delete(files, i, i+1)
+	}
+
+	for _, file := range files {
+		if err = os.Rename(path.Join(swap, file.Name()), path.Join(dir, file.Name())); err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(swap)
+}
+
+// removeSegmentFiles deletes the files of all the segments of the data directory.
+func removeSegmentFiles(dir string) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if slices.Contains(dataDirEntries, file.Name()) {
+			continue
+		}
+		if _, _, ok, _ := segmentFile(file.Name()); !ok {
+			continue
+		}
+
+		if err = os.Remove(path.Join(dir, file.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// records calls fn for every record of the segment.
+func (s *segment) records(fn func(record *log_v1.Record) error) error {
+	return s.frames(func(_ frame, records []*log_v1.Record) error {
+		for _, record := range records {
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// frames calls fn for every frame of the segment along with its records.
+func (s *segment) frames(fn func(f frame, records []*log_v1.Record) error) error {
+	return s.scanFrames(s.baseOffset, 0, func(off, pos uint64, f frame, records []*log_v1.Record) (bool, error) {
+		if records == nil {
+			return false, s.corruptErr(off, pos)
+		}
+		return true, fn(f, records)
+	})
+}
+
+// compactLoop periodically compacts the closed segments until closing is closed.
+func (l *Log) compactLoop(closing <-chan struct{}) {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.Config.Compaction.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closing:
+			return
+		case now := <-ticker.C:
+			compacted, err := l.Compact(now)
+			for _, seg := range compacted {
+				l.logger.Info(
+					"compacted segment",
+					zap.Uint64("base_offset", seg.BaseOffset),
+					zap.Uint64("next_offset", seg.NextOffset),
+					zap.Uint64("kept", seg.Kept),
+					zap.Uint64("dropped", seg.Dropped),
+					zap.Uint64("bytes", seg.Bytes),
+					zap.Uint64("compacted_bytes", seg.CompactedBytes),
+				)
+			}
+
+			if err != nil {
+				l.logger.Error("failed to compact segments", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package log
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+)
+
+var compactionStart = time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+
+func TestCompact(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 3
+	log := newTestLog(t, c)
+	appendKeyed(t, log,
+		"a", "1", "b", "1", "a", "2",
+		"c", "1", "b", "2", "", "x",
+		"a", "", "c", "2", "d", "1",
+	)
+	require.Len(t, log.segments(), 4)
+
+	compacted, err := log.Compact(compactionStart.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, compacted, 2)
+	require.Equal(t, CompactedSegment{BaseOffset: 0, NextOffset: 3, Kept: 0, Dropped: 3, Bytes: compacted[0].Bytes}, compacted[0])
+	require.Equal(t, uint64(3), compacted[1].BaseOffset)
+	require.Equal(t, uint64(2), compacted[1].Kept)
+	require.Equal(t, uint64(1), compacted[1].Dropped)
+	require.Less(t, compacted[1].CompactedBytes, compacted[1].Bytes)
+
+	// offsets are preserved, reading a removed one gives the next record, the tombstone is still there
+	requireRecord(t, log, 0, 4, "b", "2")
+	requireRecord(t, log, 3, 4, "b", "2")
+	requireRecord(t, log, 5, 5, "", "x")
+	requireRecord(t, log, 6, 6, "a", "")
+
+	// a range read skips the removed offsets too
+	frames, first, next, err := log.ReadRange(0, 1<<20)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), first)
+	require.Equal(t, uint64(9), next)
+	require.Equal(t, []uint64{4, 5, 6, 7, 8}, decodeOffsets(t, frames))
+
+	// the tombstone goes away once old enough
+	compacted, err = log.Compact(compactionStart.Add(48 * time.Hour))
+	require.NoError(t, err)
+	require.Len(t, compacted, 1)
+	require.Equal(t, uint64(6), compacted[0].BaseOffset)
+	requireRecord(t, log, 6, 7, "c", "2")
+
+	require.NoError(t, log.Close())
+	log, err = NewLog(log.Dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	requireRecord(t, log, 0, 4, "b", "2")
+	requireRecord(t, log, 6, 7, "c", "2")
+	requireRecord(t, log, 8, 8, "d", "1")
+
+	off, err := log.Append(&log_v1.Record{Value: []byte("y")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(9), off)
+}
+
+func TestCompactRemovesEmptySegments(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 3
+	log := newTestLog(t, c)
+	defer log.Close()
+
+	appendKeyed(t, log,
+		"a", "1", "b", "1", "c", "1",
+		"a", "2", "b", "2", "c", "2",
+		"a", "3", "b", "3", "c", "3",
+	)
+
+	_, err := log.Compact(compactionStart.Add(time.Minute))
+	require.NoError(t, err)
+
+	// the first segment stays to tell the lowest offset, the emptied one in the middle is removed
+	require.Len(t, log.segments(), 3)
+	require.Equal(t, uint64(0), log.segments()[0].baseOffset)
+	require.Equal(t, uint64(6), log.segments()[1].baseOffset)
+	requireRecord(t, log, 0, 6, "a", "3")
+	requireRecord(t, log, 4, 6, "a", "3")
+}
+
+func TestCompactBatch(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth
+	c.Compression.Codec = CodecGzip
+	log := newTestLog(t, c)
+	defer log.Close()
+
+	var batch []*log_v1.Record
+	for _, kv := range [][2]string{{"a", "1"}, {"b", "1"}, {"a", "2"}, {"c", "1"}} {
+		batch = append(batch, &log_v1.Record{Key: []byte(kv[0]), Value: []byte(kv[1]), Timestamp: compactionStart.UnixNano()})
+	}
+	_, err := log.AppendBatch(batch)
+	require.NoError(t, err)
+	appendKeyed(t, log, "b", "2")
+	require.Len(t, log.segments(), 3)
+
+	compacted, err := log.Compact(compactionStart.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, compacted, 1)
+	require.Equal(t, uint64(2), compacted[0].Kept)
+	require.Equal(t, uint64(2), compacted[0].Dropped)
+
+	// the records left of the batch make a new one
+	f, err := log.segments()[0].store.readFrame(0)
+	require.NoError(t, err)
+	require.NotZero(t, f.attrs&attrBatch)
+
+	requireRecord(t, log, 0, 2, "a", "2")
+	requireRecord(t, log, 3, 3, "c", "1")
+	requireRecord(t, log, 4, 4, "b", "2")
+	frames, first, next, err := log.ReadRange(0, 1<<20)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), first)
+	require.Equal(t, uint64(5), next)
+	require.Equal(t, []uint64{2, 3, 4}, decodeOffsets(t, frames))
+}
+
+func TestCompactionFinishesInterruptedSwap(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 3
+	log := newTestLog(t, c)
+	dir := log.Dir
+	appendKeyed(t, log, "a", "1", "b", "1", "a", "2")
+	require.NoError(t, log.Close())
+
+	// a rewritten first segment was committed, but its files were not moved yet
+	swapped, err := os.MkdirTemp(dir, "")
+	require.NoError(t, err)
+	seg, err := newSegment(swapped, 0, log.Config)
+	require.NoError(t, err)
+	records := map[uint64]*log_v1.Record{
+		1: {Key: []byte("b"), Value: []byte("1")},
+		2: {Key: []byte("a"), Value: []byte("2")},
+	}
+	for off := uint64(1); off <= 2; off++ {
+		require.NoError(t, seg.write([]*log_v1.Record{records[off]}, off))
+	}
+	require.NoError(t, seg.Close())
+	require.NoError(t, os.Rename(swapped, path.Join(dir, swapDir)))
+
+	// while a segment still being rewritten is discarded
+	require.NoError(t, os.Mkdir(path.Join(dir, cleanedDir), 0755))
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	requireRecord(t, log, 0, 1, "b", "1")
+	require.NoDirExists(t, path.Join(dir, swapDir))
+	require.NoDirExists(t, path.Join(dir, cleanedDir))
+}
+
+// appendKeyed appends a record for every key and value pair, an empty key makes a record without key.
+func appendKeyed(t *testing.T, log *Log, kv ...string) {
+	t.Helper()
+
+	for i := 0; i < len(kv); i += 2 {
+		record := &log_v1.Record{Value: []byte(kv[i+1]), Timestamp: compactionStart.UnixNano()}
+		if kv[i] != "" {
+			record.Key = []byte(kv[i])
+		}
+
+		_, err := log.Append(record)
+		require.NoError(t, err)
+	}
+}
+
+func requireRecord(t *testing.T, log *Log, off, want uint64, key, value string) {
+	t.Helper()
+
+	record, err := log.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, want, record.Offset)
+	require.Equal(t, key, string(record.Key))
+	require.Equal(t, value, string(record.Value))
+}
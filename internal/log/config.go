@@ -1,9 +1,96 @@
 package log
 
+import (
+	"time"
+)
+
 type Config struct {
 	Segment struct {
 		MaxStoreBytes uint64
 		MaxIndexBytes uint64
 		InitialOffset uint64
+		// IndexIntervalBytes is the number of store bytes between two index entries, zero indexes every record. A sparse
+		// index lets segments hold more small records, at the cost of scanning the store on reads.
+		IndexIntervalBytes uint64
+		// TimeIndexIntervalBytes is the number of store bytes between two entries of the time index.
+		TimeIndexIntervalBytes uint64
+		// MaxAge rolls the active segment once it was created that long ago, so a log receiving few records still has
+		// closed segments for the retention to reclaim. Zero rolls segments by size only.
+		MaxAge time.Duration
+	}
+	Durability struct {
+		Policy SyncPolicy
+		// Records and Bytes are the thresholds of SyncBatch, whichever is reached first triggers the sync.
+		Records uint64
+		Bytes   uint64
+		// Interval is the period of SyncInterval.
+		Interval time.Duration
+	}
+	GroupCommit struct {
+		// Window is how long concurrent appends are collected to be committed together, zero disables group commit.
+		Window time.Duration
+		// MaxRecords and MaxBytes commit the collected appends before the window ends, zero means no limit.
+		MaxRecords int
+		MaxBytes   uint64
+	}
+	Cache struct {
+		// MaxBytes bounds the size of the recently appended or read records kept in memory, zero disables the cache.
+		MaxBytes uint64
+	}
+	Encryption struct {
+		// Keyring encrypts the appended records, nil stores them in clear.
+		Keyring *Keyring
+	}
+	Compression struct {
+		Codec Codec
+		// MinBytes is the size under which records are stored uncompressed, as compressing them rarely pays off.
+		MinBytes int
+	}
+	Retention struct {
+		// MaxAge is how long a closed segment is kept once its newest record was written, zero keeps it forever.
+		MaxAge time.Duration
+		// MaxBytes caps the disk space taken by the segments, zero means no limit.
+		MaxBytes uint64
+		// CheckInterval is how often the retention policy is applied in the background.
+		CheckInterval time.Duration
+	}
+	Tiering struct {
+		// Store receives a copy of the closed segments, nil keeps them on the local disk only. The retention policy does
+		// not apply to the offloaded segments, they are only deleted by Truncate and Remove.
+		Store ObjectStore
+		// LocalMaxBytes caps the disk space taken by the segments locally, the oldest offloaded ones are then read from
+		// the store. Zero keeps the local copies.
+		LocalMaxBytes uint64
+		// CachedSegments is the number of offloaded segments kept on the local disk once fetched for reading.
+		CachedSegments int
+		// Interval is how often the closed segments are offloaded.
+		Interval time.Duration
+	}
+	Topic struct {
+		// Partitions is the number of partitions of a topic created without an explicit count, zero means one. It is only
+		// used by LogManager, the logs being the partitions.
+		Partitions int
+	}
+	Compaction struct {
+		// Enabled turns on the background compaction of the closed segments.
+		Enabled bool
+		// TombstoneRetention is how long a record without a value is kept once written, so consumers see the deletion.
+		TombstoneRetention time.Duration
+		// Interval is how often the closed segments are compacted.
+		Interval time.Duration
 	}
 }
+
+// SyncPolicy tells when appended records are flushed to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncOnClose syncs segments only when they are closed.
+	SyncOnClose SyncPolicy = iota
+	// SyncAlways syncs the active segment before every append returns.
+	SyncAlways
+	// SyncBatch syncs the active segment once enough records or bytes have been appended since the last sync.
+	SyncBatch
+	// SyncInterval syncs the active segment periodically from a background goroutine.
+	SyncInterval
+)
@@ -0,0 +1,151 @@
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// formatVersion is the version of the on-disk format written by the log, minFormatVersion the oldest one it reads.
+	// The second format adds the batch frames.
+	formatVersion    = 2
+	minFormatVersion = 1
+
+	// lockFile is locked by the log owning the data directory.
+	lockFile = ".lock"
+	// manifestFile records the format of the data directory.
+	manifestFile = "manifest.json"
+)
+
+var (
+	// ErrDataDirLocked is returned when opening a data directory already used by another log.
+	ErrDataDirLocked = errors.New("data directory is used by another log")
+	// ErrIncompatibleFormat is returned when opening a data directory written in a format the log cannot read.
+	ErrIncompatibleFormat = errors.New("incompatible data directory format")
+)
+
+// segmentExts are the extensions of the files making a segment, which are named after its base offset.
+var segmentExts = []string{".store", ".index", ".timeindex", ".created"}
+
+// dataDirEntries are the entries of the data directory which are not segment files.
+var dataDirEntries = []string{lockFile, manifestFile, manifestFile + ".tmp", cleanedDir, swapDir, restoreDir, remoteDir}
+
+// manifest describes the content of the data directory.
+type manifest struct {
+	FormatVersion int `json:"format_version"`
+	Segment       struct {
+		MaxStoreBytes      uint64 `json:"max_store_bytes"`
+		MaxIndexBytes      uint64 `json:"max_index_bytes"`
+		IndexIntervalBytes uint64 `json:"index_interval_bytes"`
+	} `json:"segment"`
+}
+
+// lockDir takes the lock file of the data directory, which is released once the returned file is closed. The lock is
+// exclusive, even within the same process.
+func lockDir(dir string) (*os.File, error) {
+	f, err := os.OpenFile(path.Join(dir, lockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrDataDirLocked
+		}
+		return nil, err
+	}
+
+	// the pid tells who holds the lock
+	if err = f.Truncate(0); err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// unlock releases the lock of the data directory.
+func (l *Log) unlock() error {
+	if l.lock == nil {
+		return nil
+	}
+
+	err := l.lock.Close()
+	l.lock = nil
+
+	return err
+}
+
+// checkManifest refuses a data directory written in an incompatible format and records the current format and segment
+// config in the manifest. A directory without manifest was written before the manifest was introduced, in the first
+// format.
+func (l *Log) checkManifest() error {
+	name := path.Join(l.Dir, manifestFile)
+	p, err := os.ReadFile(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err == nil {
+		var m manifest
+		if err = json.Unmarshal(p, &m); err != nil {
+			return fmt.Errorf("%w: %v", ErrIncompatibleFormat, err)
+		}
+		if m.FormatVersion < minFormatVersion || m.FormatVersion > formatVersion {
+			return fmt.Errorf("%w: version %d", ErrIncompatibleFormat, m.FormatVersion)
+		}
+
+		if s := l.Config.Segment; m.Segment.MaxStoreBytes != s.MaxStoreBytes || m.Segment.MaxIndexBytes != s.MaxIndexBytes || m.Segment.IndexIntervalBytes != s.IndexIntervalBytes {
+			l.logger.Info(
+				"segment config changed",
+				zap.Uint64("max_store_bytes", s.MaxStoreBytes),
+				zap.Uint64("max_index_bytes", s.MaxIndexBytes),
+				zap.Uint64("index_interval_bytes", s.IndexIntervalBytes),
+			)
+		}
+	}
+
+	m := manifest{FormatVersion: formatVersion}
+	m.Segment.MaxStoreBytes = l.Config.Segment.MaxStoreBytes
+	m.Segment.MaxIndexBytes = l.Config.Segment.MaxIndexBytes
+	m.Segment.IndexIntervalBytes = l.Config.Segment.IndexIntervalBytes
+	if p, err = json.MarshalIndent(m, "", "  "); err != nil {
+		return err
+	}
+
+	// the manifest is replaced at once, so it is never seen partially written
+	if err = os.WriteFile(name+".tmp", p, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(name+".tmp", name)
+}
+
+// segmentFile returns the base offset and the extension of the segment file of the given name. It returns false for a
+// file which is not part of a segment, and an error for a file named like one but without a valid base offset.
+func segmentFile(name string) (uint64, string, bool, error) {
+	ext := path.Ext(name)
+	if !slices.Contains(segmentExts, ext) {
+		return 0, "", false, nil
+	}
+
+	off, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 64)
+	if err != nil {
+		return 0, "", false, fmt.Errorf("invalid segment file %q: %w", name, err)
+	}
+
+	return off, ext, true, nil
+}
@@ -0,0 +1,92 @@
+package log
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataDirLock(t *testing.T) {
+	dir, err := os.MkdirTemp("", "datadir-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+
+	_, err = NewLog(dir, Config{})
+	require.ErrorIs(t, err, ErrDataDirLocked)
+
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, Config{})
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+}
+
+func TestManifest(t *testing.T) {
+	dir, err := os.MkdirTemp("", "datadir-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 4096
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	appendRecords(t, log, 2)
+	require.NoError(t, log.Close())
+
+	m := readManifest(t, dir)
+	require.Equal(t, formatVersion, m.FormatVersion)
+	require.Equal(t, uint64(4096), m.Segment.MaxStoreBytes)
+
+	// a directory written before the manifest is taken as the first format
+	require.NoError(t, os.Remove(path.Join(dir, manifestFile)))
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+	require.Equal(t, formatVersion, readManifest(t, dir).FormatVersion)
+
+	for _, p := range []string{`{"format_version": 99}`, `{"format_version": 0}`, `not json`} {
+		require.NoError(t, os.WriteFile(path.Join(dir, manifestFile), []byte(p), 0644))
+		_, err = NewLog(dir, c)
+		require.ErrorIs(t, err, ErrIncompatibleFormat)
+	}
+}
+
+func TestUnknownDataDirFiles(t *testing.T) {
+	dir, err := os.MkdirTemp("", "datadir-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	appendRecords(t, log, 2)
+	require.NoError(t, log.Close())
+
+	// a stray file is skipped instead of becoming a segment
+	require.NoError(t, os.WriteFile(path.Join(dir, "notes.txt"), nil, 0644))
+	log, err = NewLog(dir, Config{})
+	require.NoError(t, err)
+	require.Len(t, log.segments(), 1)
+	require.NoError(t, log.Close())
+
+	// a file named like a segment but without base offset is rejected
+	require.NoError(t, os.WriteFile(path.Join(dir, "backup.store"), nil, 0644))
+	_, err = NewLog(dir, Config{})
+	require.ErrorContains(t, err, "backup.store")
+}
+
+func readManifest(t *testing.T, dir string) manifest {
+	t.Helper()
+
+	p, err := os.ReadFile(path.Join(dir, manifestFile))
+	require.NoError(t, err)
+
+	var m manifest
+	require.NoError(t, json.Unmarshal(p, &m))
+
+	return m
+}
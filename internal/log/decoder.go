@@ -0,0 +1,79 @@
+package log
+
+import (
+	"bufio"
+	"hash/crc32"
+	"io"
+
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+)
+
+// Decoder reads the records out of a stream of store frames, such as the one produced by Log.Reader.
+type Decoder struct {
+	// Keyring decrypts the encrypted records, which cannot be decoded without it.
+	Keyring *Keyring
+
+	r *bufio.Reader
+	// records are the records of the last frame read which were not returned yet
+	records []*log_v1.Record
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode returns the next record of the stream, or io.EOF once the stream is exhausted.
+func (d *Decoder) Decode() (*log_v1.Record, error) {
+	for len(d.records) == 0 {
+		f, err := d.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if d.records, err = decodeRecords(f, d.Keyring); err != nil {
+			return nil, err
+		}
+	}
+
+	record := d.records[0]
+	d.records = d.records[1:]
+
+	return record, nil
+}
+
+// next returns the next frame of the stream once its checksum is verified.
+func (d *Decoder) next() (frame, error) {
+	header := make([]byte, lenWidth)
+	if _, err := io.ReadFull(d.r, header); err != nil {
+		return frame{}, err
+	}
+
+	attrs, size := decodeLen(enc.Uint64(header))
+	var sum []byte
+	if attrs&attrChecksum != 0 {
+		sum = make([]byte, crcWidth)
+		if _, err := io.ReadFull(d.r, sum); err != nil {
+			return frame{}, unexpectedEOF(err)
+		}
+	}
+
+	p := make([]byte, size)
+	if _, err := io.ReadFull(d.r, p); err != nil {
+		return frame{}, unexpectedEOF(err)
+	}
+
+	if sum != nil && crc32.Checksum(p, crcTable) != enc.Uint32(sum) {
+		return frame{}, errCorruptFrame
+	}
+
+	return frame{attrs: attrs, payload: p}, nil
+}
+
+// unexpectedEOF reports a stream ending in the middle of a frame as such.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
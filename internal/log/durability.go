@@ -0,0 +1,81 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSyncInterval = time.Second
+)
+
+// ErrNotDurable is returned along with the offsets of records which were appended but could not be synced as the
+// durability policy requires. The records are readable, so appending them again would duplicate them, but they may be
+// lost on a crash until the next sync succeeds.
+var ErrNotDurable = errors.New("appended records not synced")
+
+// maybeSync accounts for the records and bytes appended to the active segment and syncs it when the durability policy
+// requires so. A failed sync is reported with ErrNotDurable. The caller must hold the lock.
+func (l *Log) maybeSync(records, n uint64) error {
+	l.unsyncedRecords += records
+	l.unsyncedBytes += n
+
+	var err error
+	switch d := l.Config.Durability; d.Policy {
+	case SyncAlways:
+		err = l.sync()
+	case SyncBatch:
+		if d.Records != 0 && l.unsyncedRecords >= d.Records || d.Bytes != 0 && l.unsyncedBytes >= d.Bytes {
+			err = l.sync()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNotDurable, err)
+	}
+
+	return nil
+}
+
+// sync commits the active segment to stable storage. The caller must hold the lock.
+func (l *Log) sync() error {
+	if l.unsyncedRecords == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	if err := l.activeSegment.Sync(); err != nil {
+		return err
+	}
+	l.stats.observeSync(time.Since(start))
+
+	l.unsyncedRecords = 0
+	l.unsyncedBytes = 0
+
+	return nil
+}
+
+// syncLoop periodically syncs the active segment until closing is closed.
+func (l *Log) syncLoop(closing <-chan struct{}) {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.Config.Durability.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closing:
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			err := l.sync()
+			l.mu.Unlock()
+
+			if err != nil {
+				l.logger.Error("failed to sync active segment", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package log
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+)
+
+func TestDurability(t *testing.T) {
+	for scenario, tc := range map[string]struct {
+		configure func(c *Config)
+		appends   int
+		syncs     uint64
+	}{
+		"sync on close": {
+			configure: func(c *Config) {},
+			appends:   5,
+			syncs:     0,
+		},
+		"sync always": {
+			configure: func(c *Config) { c.Durability.Policy = SyncAlways },
+			appends:   5,
+			syncs:     5,
+		},
+		"sync every 2 records": {
+			configure: func(c *Config) {
+				c.Durability.Policy = SyncBatch
+				c.Durability.Records = 2
+			},
+			appends: 5,
+			syncs:   2,
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			c := Config{}
+			tc.configure(&c)
+			log := newTestLog(t, c)
+			defer log.Remove()
+
+			appendRecords(t, log, tc.appends)
+			stats := log.Stats()
+			require.Equal(t, tc.syncs, stats.Syncs)
+			if tc.syncs > 0 {
+				require.NotZero(t, stats.SyncTime)
+				require.True(t, stats.MaxSyncTime >= stats.AvgSyncTime())
+			}
+		})
+	}
+
+	t.Run("sync on interval", func(t *testing.T) {
+		c := Config{}
+		c.Durability.Policy = SyncInterval
+		c.Durability.Interval = 10 * time.Millisecond
+		log := newTestLog(t, c)
+		defer log.Remove()
+
+		appendRecords(t, log, 3)
+		require.Eventually(t, func() bool {
+			return log.Stats().Syncs == 1
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestDurabilitySyncFailure(t *testing.T) {
+	for scenario, window := range map[string]time.Duration{
+		"append":       0,
+		"group commit": 10 * time.Millisecond,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			c := Config{}
+			c.Durability.Policy = SyncBatch
+			c.Durability.Records = 2
+			c.GroupCommit.Window = window
+			log := newTestLog(t, c)
+			defer log.Close()
+
+			record := &log_v1.Record{Value: []byte("hello world")}
+			_, err := log.Append(record)
+			require.NoError(t, err)
+
+			// the time index is not written to by the next append, only synced
+			require.NoError(t, log.activeSegment.timeIndex.file.Close())
+
+			off, err := log.Append(record)
+			require.ErrorIs(t, err, ErrNotDurable)
+			require.Equal(t, uint64(1), off)
+
+			read, err := log.Read(off)
+			require.NoError(t, err)
+			require.Equal(t, record.Value, read.Value)
+		})
+	}
+}
+
+func appendRecords(t *testing.T, log *Log, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		_, err := log.Append(&log_v1.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+}
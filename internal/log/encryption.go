@@ -0,0 +1,200 @@
+package log
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+)
+
+const (
+	keyIDWidth = 4
+	nonceWidth = 12
+	tagWidth   = 16
+	// encryptionOverhead is what encryption adds to a record: the key ID, the nonce and the authentication tag.
+	encryptionOverhead = keyIDWidth + nonceWidth + tagWidth
+)
+
+var (
+	// errUnknownKey is returned when a record was encrypted with a key the keyring does not hold.
+	errUnknownKey = errors.New("unknown encryption key")
+	// errNoKeyring is returned when re-encrypting a log without keyring.
+	errNoKeyring = errors.New("no encryption keyring")
+)
+
+// Keyring holds the AES keys records are encrypted with, by ID. Records are encrypted with AES-GCM under the current
+// key and carry its ID, so they are decrypted with the key they were encrypted with once the current key is rotated.
+// Retired keys have to stay in the keyring as long as records encrypted with them are kept, see Log.Reencrypt.
+type Keyring struct {
+	mu      sync.RWMutex
+	current uint32
+	aeads   map[uint32]cipher.AEAD
+}
+
+// NewKeyring returns a keyring encrypting with the key of the current ID. Keys are 16, 24 or 32 bytes long, for
+// AES-128, AES-192 or AES-256.
+func NewKeyring(current uint32, keys map[uint32][]byte) (*Keyring, error) {
+	k := &Keyring{aeads: make(map[uint32]cipher.AEAD)}
+	for id, key := range keys {
+		if err := k.Add(id, key); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := k.Use(current); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// Add adds the key under the ID, replacing the key already there.
+func (k *Keyring) Add(id uint32, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.aeads[id] = aead
+	return nil
+}
+
+// Use makes the key of the ID the one new records are encrypted with.
+func (k *Keyring) Use(id uint32) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.aeads[id]; !ok {
+		return fmt.Errorf("%w: %d", errUnknownKey, id)
+	}
+
+	k.current = id
+	return nil
+}
+
+// Current returns the ID of the key new records are encrypted with.
+func (k *Keyring) Current() uint32 {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.current
+}
+
+// seal encrypts p under the current key and prefixes it with the key ID and the nonce.
+func (k *Keyring) seal(p []byte) ([]byte, error) {
+	k.mu.RLock()
+	id, aead := k.current, k.aeads[k.current]
+	k.mu.RUnlock()
+
+	out := make([]byte, keyIDWidth+nonceWidth, encryptionOverhead+len(p))
+	enc.PutUint32(out, id)
+	if _, err := rand.Read(out[keyIDWidth:]); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(out, out[keyIDWidth:], p, nil), nil
+}
+
+// open decrypts the payload sealed by seal with the key it names.
+func (k *Keyring) open(p []byte) ([]byte, error) {
+	if len(p) < encryptionOverhead {
+		return nil, errCorruptFrame
+	}
+
+	id := keyID(p)
+	k.mu.RLock()
+	aead, ok := k.aeads[id]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", errUnknownKey, id)
+	}
+
+	return aead.Open(nil, p[keyIDWidth:keyIDWidth+nonceWidth], p[keyIDWidth+nonceWidth:], nil)
+}
+
+// keyID returns the ID of the key the sealed payload was encrypted with.
+func keyID(p []byte) uint32 {
+	return enc.Uint32(p)
+}
+
+// ReencryptedSegment describes a segment rewritten by Reencrypt.
+type ReencryptedSegment struct {
+	BaseOffset uint64
+	NextOffset uint64
+	// Records is the number of records encrypted again.
+	Records uint64
+}
+
+// Reencrypt rewrites the segments holding records encrypted under another key than the current one, or not encrypted
+// at all, so they are all encrypted under the current key and the other keys can be retired. The active segment is
+// rolled beforehand so its records are rewritten too. Segments offloaded to tiered storage and removed from the local
+// disk are not rewritten, the remote copy of the rewritten local ones is offloaded again.
+func (l *Log) Reencrypt() ([]ReencryptedSegment, error) {
+	keyring := l.Config.Encryption.Keyring
+	if keyring == nil {
+		return nil, errNoKeyring
+	}
+
+	l.maintenance.Lock()
+	defer l.maintenance.Unlock()
+
+	l.mu.Lock()
+	var err error
+	if next := l.activeSegment.nextOffset.Load(); next != l.activeSegment.baseOffset {
+		err = l.newSegment(next)
+	}
+	l.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	segments := l.segments()
+	current := keyring.Current()
+
+	var reencrypted []ReencryptedSegment
+	for _, seg := range segments[:len(segments)-1] {
+		info := ReencryptedSegment{BaseOffset: seg.baseOffset, NextOffset: seg.nextOffset.Load()}
+		_, err := l.rewriteSegment(seg, func(f frame, records []*log_v1.Record) (frame, []*log_v1.Record, error) {
+			p := f.payload
+			if f.attrs&attrEncrypted != 0 {
+				if keyID(p) == current {
+					return f, records, nil
+				}
+
+				var err error
+				if p, err = keyring.open(p); err != nil {
+					return f, nil, err
+				}
+			}
+
+			sealed, err := keyring.seal(p)
+			if err != nil {
+				return f, nil, err
+			}
+			info.Records += uint64(len(records))
+
+			return frame{attrs: f.attrs | attrEncrypted, payload: sealed}, records, nil
+		})
+		if err != nil {
+			return reencrypted, err
+		}
+
+		if info.Records != 0 {
+			reencrypted = append(reencrypted, info)
+		}
+	}
+
+	return reencrypted, nil
+}
@@ -0,0 +1,117 @@
+package log
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+)
+
+var (
+	key1 = bytes.Repeat([]byte{1}, 16)
+	key2 = bytes.Repeat([]byte{2}, 32)
+)
+
+func TestKeyring(t *testing.T) {
+	_, err := NewKeyring(1, map[uint32][]byte{1: []byte("short")})
+	require.Error(t, err)
+
+	_, err = NewKeyring(2, map[uint32][]byte{1: key1})
+	require.ErrorIs(t, err, errUnknownKey)
+
+	k, err := NewKeyring(1, map[uint32][]byte{1: key1})
+	require.NoError(t, err)
+
+	sealed, err := k.seal([]byte("hello world"))
+	require.NoError(t, err)
+	require.Len(t, sealed, len("hello world")+encryptionOverhead)
+	require.Equal(t, uint32(1), keyID(sealed))
+
+	// the key used for sealing stays usable once rotated
+	require.NoError(t, k.Add(2, key2))
+	require.NoError(t, k.Use(2))
+	p, err := k.open(sealed)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(p))
+
+	other, err := NewKeyring(2, map[uint32][]byte{2: key2})
+	require.NoError(t, err)
+	_, err = other.open(sealed)
+	require.ErrorIs(t, err, errUnknownKey)
+
+	sealed[len(sealed)-1] ^= 1
+	_, err = k.open(sealed)
+	require.Error(t, err)
+}
+
+func TestEncryptedLog(t *testing.T) {
+	dir, err := os.MkdirTemp("", "encryption-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	keyring, err := NewKeyring(1, map[uint32][]byte{1: key1})
+	require.NoError(t, err)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 2
+	c.Compression.Codec = CodecGzip
+	c.Encryption.Keyring = keyring
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	value := bytes.Repeat([]byte("secret"), 10)
+	appendValues := func(n int) {
+		for i := 0; i < n; i++ {
+			_, err := log.Append(&log_v1.Record{Value: value})
+			require.NoError(t, err)
+		}
+	}
+	requireValues := func(log *Log, n uint64) {
+		for off := uint64(0); off < n; off++ {
+			record, err := log.Read(off)
+			require.NoError(t, err)
+			require.Equal(t, value, record.Value)
+		}
+	}
+
+	appendValues(3)
+	require.NoError(t, keyring.Add(2, key2))
+	require.NoError(t, keyring.Use(2))
+	appendValues(2)
+	requireValues(log, 5)
+
+	// the stored frames are read back with the keyring
+	frames, _, _, err := log.ReadRange(0, 1<<20)
+	require.NoError(t, err)
+	require.NotContains(t, string(frames), "secret")
+	dec := NewDecoder(bytes.NewReader(frames))
+	_, err = dec.Decode()
+	require.ErrorIs(t, err, errUnknownKey)
+	dec = NewDecoder(bytes.NewReader(frames))
+	dec.Keyring = keyring
+	record, err := dec.Decode()
+	require.NoError(t, err)
+	require.Equal(t, value, record.Value)
+
+	reencrypted, err := log.Reencrypt()
+	require.NoError(t, err)
+	require.Equal(t, []ReencryptedSegment{
+		{BaseOffset: 0, NextOffset: 2, Records: 2},
+		{BaseOffset: 2, NextOffset: 4, Records: 1},
+	}, reencrypted)
+	require.NoError(t, log.Close())
+
+	// the first key is not needed anymore
+	c.Encryption.Keyring, err = NewKeyring(2, map[uint32][]byte{2: key2})
+	require.NoError(t, err)
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	requireValues(log, 5)
+
+	reencrypted, err = log.Reencrypt()
+	require.NoError(t, err)
+	require.Empty(t, reencrypted)
+}
@@ -0,0 +1,151 @@
+package log
+
+import (
+	"errors"
+	"time"
+
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+type appendRequest struct {
+	record *log_v1.Record
+	done   chan appendResult
+}
+
+type appendResult struct {
+	off uint64
+	err error
+}
+
+// groupCommit hands the records appended concurrently over to the committer, which writes and syncs them together.
+type groupCommit struct {
+	requests chan *appendRequest
+	closing  <-chan struct{}
+}
+
+func newGroupCommit(closing <-chan struct{}) *groupCommit {
+	return &groupCommit{
+		requests: make(chan *appendRequest),
+		closing:  closing,
+	}
+}
+
+// submit waits for the record to be committed. It returns false when the log is closing, in which case the record was
+// not appended.
+func (g *groupCommit) submit(record *log_v1.Record) (appendResult, bool) {
+	req := &appendRequest{record: record, done: make(chan appendResult, 1)}
+	select {
+	case g.requests <- req:
+		return <-req.done, true
+	case <-g.closing:
+		return appendResult{}, false
+	}
+}
+
+// commitLoop collects the submitted records until the window ends or a limit is reached and commits them, until the
+// log is closing. The records already submitted are committed before it returns.
+func (l *Log) commitLoop(g *groupCommit) {
+	defer l.wg.Done()
+
+	for {
+		select {
+		case <-g.closing:
+			return
+		case req := <-g.requests:
+			l.commit(l.collect(g, req))
+		}
+	}
+}
+
+// collect gathers the requests submitted within the window opened by the first one.
+func (l *Log) collect(g *groupCommit, first *appendRequest) []*appendRequest {
+	c := l.Config.GroupCommit
+	group := []*appendRequest{first}
+	size := uint64(proto.Size(first.record))
+
+	timer := time.NewTimer(c.Window)
+	defer timer.Stop()
+
+	for (c.MaxRecords == 0 || len(group) < c.MaxRecords) && (c.MaxBytes == 0 || size < c.MaxBytes) {
+		select {
+		case req := <-g.requests:
+			group = append(group, req)
+			size += uint64(proto.Size(req.record))
+		case <-timer.C:
+			return group
+		case <-g.closing:
+			return group
+		}
+	}
+
+	return group
+}
+
+// commit appends the records of the group in the order they were submitted, so the offsets follow the commit order.
+// The records are written with a single store write per segment and every segment is synced once as the durability
+// policy requires, before it is rolled. The appended records get their offsets even when the sync fails.
+func (l *Log) commit(group []*appendRequest) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.rollIfOld(time.Now()); err != nil {
+		for _, req := range group {
+			req.done <- appendResult{err: err}
+		}
+		return
+	}
+
+	records := make([]*log_v1.Record, len(group))
+	for i, req := range group {
+		records[i] = req.record
+	}
+
+	results := make([]appendResult, len(group))
+	fail := func(from int, err error) {
+		for i := from; i < len(results); i++ {
+			results[i].err = err
+		}
+	}
+
+	for i := 0; i < len(records); {
+		k := l.activeSegment.fitting(records[i:])
+		if k == 0 && l.activeSegment.nextOffset.Load() != l.activeSegment.baseOffset {
+			if err := l.newSegment(l.activeSegment.nextOffset.Load()); err != nil {
+				fail(i, err)
+				break
+			}
+			continue
+		}
+		// a record too big for any segment goes alone in its own, as with Append
+		k = max(k, 1)
+
+		size := l.activeSegment.store.size.Load()
+		first, err := l.activeSegment.AppendBatch(records[i : i+k])
+		if err != nil {
+			fail(i, err)
+			break
+		}
+
+		// the records are appended even when they could not be synced, and synced before their segment is rolled
+		err = l.appended(records[i:i+k], l.activeSegment.store.size.Load()-size)
+		var rollErr error
+		if l.activeSegment.IsMaxed() {
+			rollErr = l.newSegment(l.activeSegment.nextOffset.Load())
+		}
+		for j := 0; j < k; j++ {
+			results[i+j] = appendResult{off: first + uint64(j), err: errors.Join(err, rollErr)}
+		}
+		i += k
+
+		if rollErr != nil {
+			fail(i, rollErr)
+			break
+		}
+	}
+	l.stats.observeGroupCommit(len(group))
+
+	for i, req := range group {
+		req.done <- results[i]
+	}
+}
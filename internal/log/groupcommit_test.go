@@ -0,0 +1,114 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+)
+
+func TestGroupCommit(t *testing.T) {
+	for scenario, fn := range map[string]func(c *Config){
+		"window with sync always": func(c *Config) {
+			c.GroupCommit.Window = 20 * time.Millisecond
+			c.Durability.Policy = SyncAlways
+			// a group spanning segments syncs each of them
+			c.Segment.MaxStoreBytes = 4096
+		},
+		"group spanning segments": func(c *Config) {
+			c.GroupCommit.Window = 20 * time.Millisecond
+			c.Segment.MaxIndexBytes = entWidth * 3
+		},
+		"max records ends the window": func(c *Config) {
+			c.GroupCommit.Window = time.Hour
+			c.GroupCommit.MaxRecords = 8
+		},
+		"compressed groups": func(c *Config) {
+			c.GroupCommit.Window = 20 * time.Millisecond
+			c.Compression.Codec = CodecSnappy
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "group-commit-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			c := Config{}
+			c.Segment.MaxIndexBytes = 1024
+			fn(&c)
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+			defer log.Close()
+
+			const appenders = 32
+			offsets := make([]uint64, appenders)
+			var wg sync.WaitGroup
+			for i := 0; i < appenders; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					off, err := log.Append(&log_v1.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+					assert.NoError(t, err)
+					offsets[i] = off
+				}(i)
+			}
+			wg.Wait()
+
+			// every caller gets the offset its own record was stored under
+			seen := make(map[uint64]bool)
+			for i, off := range offsets {
+				require.False(t, seen[off])
+				seen[off] = true
+
+				record, err := log.Read(off)
+				require.NoError(t, err)
+				require.Equal(t, fmt.Sprintf("record %d", i), string(record.Value))
+			}
+
+			stats := log.Stats()
+			require.Equal(t, uint64(appenders), stats.GroupedRecords)
+			require.Less(t, stats.GroupCommits, uint64(appenders))
+			if c.Durability.Policy == SyncAlways {
+				require.Equal(t, stats.GroupCommits, stats.Syncs)
+			}
+		})
+	}
+}
+
+func TestGroupCommitSyncsRolledSegments(t *testing.T) {
+	c := Config{}
+	// a segment holds two records at most
+	c.Segment.MaxStoreBytes = 80
+	c.Durability.Policy = SyncAlways
+	c.GroupCommit.Window = time.Hour
+	c.GroupCommit.MaxRecords = 4
+	log := newTestLog(t, c)
+	defer log.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := log.Append(&log_v1.Record{Value: []byte("hello world")})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// the group spans segments, each one holding records is synced before the appends return
+	segments := log.segments()
+	require.Greater(t, len(segments), 1)
+	synced := len(segments)
+	if active := segments[len(segments)-1]; active.nextOffset.Load() == active.baseOffset {
+		synced--
+	}
+	stats := log.Stats()
+	require.Equal(t, uint64(1), stats.GroupCommits)
+	require.Equal(t, uint64(synced), stats.Syncs)
+}
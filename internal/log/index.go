@@ -3,6 +3,8 @@ package log
 import (
 	"io"
 	"os"
+	"sort"
+	"sync/atomic"
 
 	"github.com/tysonmote/gommap"
 )
@@ -13,10 +15,12 @@ var (
 	entWidth        = offWidth + posWidth
 )
 
+// index is written by a single writer at a time. Readers load size before reading the entries, which are written
+// before size is moved past them.
 type index struct {
 	file *os.File
 	mmap gommap.MMap
-	size uint64
+	size atomic.Uint64
 }
 
 func newIndex(f *os.File, c Config) (*index, error) {
@@ -29,7 +33,7 @@ func newIndex(f *os.File, c Config) (*index, error) {
 		return nil, err
 	}
 
-	idx.size = uint64(stat.Size())
+	idx.size.Store(uint64(stat.Size()))
 
 	if err = os.Truncate(f.Name(), int64(c.Segment.MaxIndexBytes)); err != nil {
 		return nil, err
@@ -43,18 +47,19 @@ func newIndex(f *os.File, c Config) (*index, error) {
 }
 
 func (i *index) Read(in int64) (out uint32, pos uint64, err error) {
-	if i.size == 0 {
+	size := i.size.Load()
+	if size == 0 {
 		return 0, 0, io.EOF
 	}
 
 	if in == -1 {
-		out = uint32((i.size / entWidth) - 1)
+		out = uint32((size / entWidth) - 1)
 	} else {
 		out = uint32(in)
 	}
 
 	pos = uint64(out) * entWidth
-	if i.size < pos+entWidth {
+	if size < pos+entWidth {
 		return 0, 0, io.EOF
 	}
 
@@ -65,33 +70,87 @@ func (i *index) Read(in int64) (out uint32, pos uint64, err error) {
 }
 
 func (i *index) Write(off uint32, pos uint64) error {
-	if uint64(len(i.mmap)) < i.size+entWidth {
+	size := i.size.Load()
+	if uint64(len(i.mmap)) < size+entWidth {
 		return io.EOF
 	}
 
-	enc.PutUint32(i.mmap[i.size:i.size+offWidth], off)
-	enc.PutUint64(i.mmap[i.size+offWidth:i.size+entWidth], pos)
-	i.size += entWidth
+	enc.PutUint32(i.mmap[size:size+offWidth], off)
+	enc.PutUint64(i.mmap[size+offWidth:size+entWidth], pos)
+	i.size.Store(size + entWidth)
 
 	return nil
 }
 
+// lookup returns the last entry whose offset is at or before off, or the first entry when there is none. It returns
+// io.EOF when the index is empty.
+func (i *index) lookup(off uint32) (out uint32, pos uint64, err error) {
+	// dense indexes have an entry for every offset
+	if out, pos, err = i.Read(int64(off)); err == nil && out == off {
+		return out, pos, nil
+	}
+
+	n := i.search(off)
+	if out, pos, err = i.Read(int64(n)); (err != nil || out != off) && n > 0 {
+		return i.Read(int64(n - 1))
+	}
+
+	return out, pos, err
+}
+
+// search returns the number of the first entry whose offset is at or after off. Offsets are ascending but not
+// necessarily contiguous, as compaction drops the entries of the removed records and sparse indexes skip records.
+func (i *index) search(off uint32) uint64 {
+	return uint64(sort.Search(int(i.size.Load()/entWidth), func(n int) bool {
+		pos := uint64(n) * entWidth
+		return enc.Uint32(i.mmap[pos:pos+offWidth]) >= off
+	}))
+}
+
+// entries returns the number of entries up to the last non-zero one. The index file is preallocated with zeros, so
+// after an unclean shutdown its size tells nothing about the number of written entries.
+func (i *index) entries() uint64 {
+	n := i.size.Load() / entWidth
+	for ; n > 0; n-- {
+		pos := (n - 1) * entWidth
+		if enc.Uint32(i.mmap[pos:pos+offWidth]) != 0 || enc.Uint64(i.mmap[pos+offWidth:pos+entWidth]) != 0 {
+			break
+		}
+	}
+
+	return n
+}
+
+// truncate drops all the entries starting from the n-th one.
+func (i *index) truncate(n uint64) {
+	i.size.Store(n * entWidth)
+	clear(i.mmap[n*entWidth:])
+}
+
 func (i *index) Name() string {
 	return i.file.Name()
 }
 
-func (i *index) Close() error {
+func (i *index) Sync() error {
 	if err := i.mmap.Sync(gommap.MS_SYNC); err != nil {
 		return err
 	}
 
-	if err := i.file.Sync(); err != nil {
+	return i.file.Sync()
+}
+
+func (i *index) Close() error {
+	if err := i.Sync(); err != nil {
 		return err
 	}
 
-	if err := i.file.Truncate(int64(i.size)); err != nil {
+	if err := i.mmap.UnsafeUnmap(); err != nil {
 		return err
 	}
 
-	return nil
+	if err := i.file.Truncate(int64(i.size.Load())); err != nil {
+		return err
+	}
+
+	return i.file.Close()
 }
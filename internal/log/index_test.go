@@ -40,7 +40,11 @@ func TestIndex(t *testing.T) {
 
 	_, _, err = idx.Read(int64(len(entries)))
 	require.Equal(t, io.EOF, err)
-	_ = idx.Close()
+	require.NoError(t, idx.Close())
+
+	// closing releases the file
+	_, err = file.Stat()
+	require.ErrorIs(t, err, os.ErrClosed)
 
 	file, _ = os.OpenFile(file.Name(), os.O_RDWR, 0600)
 	idx, err = newIndex(file, c)
@@ -50,3 +54,40 @@ func TestIndex(t *testing.T) {
 	require.Equal(t, uint32(1), off)
 	require.Equal(t, entries[1].Pos, pos)
 }
+
+func TestIndexLookup(t *testing.T) {
+	file, err := os.CreateTemp(os.TempDir(), "index_test")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = 1024
+	idx, err := newIndex(file, c)
+	require.NoError(t, err)
+	defer idx.Close()
+
+	_, _, err = idx.lookup(0)
+	require.Equal(t, io.EOF, err)
+
+	// a sparse index, as if every fourth record was indexed
+	for _, off := range []uint32{2, 6, 10} {
+		require.NoError(t, idx.Write(off, uint64(off)*100))
+	}
+
+	for _, tc := range []struct {
+		off  uint32
+		want uint32
+	}{
+		{off: 0, want: 2},
+		{off: 2, want: 2},
+		{off: 5, want: 2},
+		{off: 6, want: 6},
+		{off: 9, want: 6},
+		{off: 42, want: 10},
+	} {
+		out, pos, err := idx.lookup(tc.off)
+		require.NoError(t, err)
+		require.Equal(t, tc.want, out, tc.off)
+		require.Equal(t, uint64(tc.want)*100, pos)
+	}
+}
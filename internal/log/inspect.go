@@ -0,0 +1,317 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+)
+
+// SegmentInfo describes a segment found in a data directory.
+type SegmentInfo struct {
+	BaseOffset uint64
+	// NextOffset is the offset following the segment: the base offset of the next segment, as compaction may have
+	// removed the last records of a closed one, or the offset following the last record of the active segment.
+	NextOffset uint64
+	Records    uint64
+	StoreBytes uint64
+	IndexBytes uint64
+	// CorruptFrames is the number of frames failing their checksum. Err is the damage which stopped reading the store, if
+	// any, the records after it are not counted.
+	CorruptFrames uint64
+	Err           error
+}
+
+// IndexError reports an index entry which does not point at the frame of its record.
+type IndexError struct {
+	BaseOffset uint64
+	// Entry is the number of the entry in the index, Offset and Position what it holds.
+	Entry    uint64
+	Offset   uint64
+	Position uint64
+	Reason   string
+}
+
+func (e IndexError) Error() string {
+	return fmt.Sprintf(
+		"segment %d: index entry %d (offset %d, position %d): %s",
+		e.BaseOffset, e.Entry, e.Offset, e.Position, e.Reason,
+	)
+}
+
+// The functions below inspect the data directory of a log which is not running. They only read the segment files, so
+// they may also be used on a running log, but then see the files as they are on disk, without the buffered records.
+
+// InspectSegments describes the segments of the data directory, ordered by base offset. The keyring of the config is
+// needed to find the offsets of encrypted records.
+func InspectSegments(dir string, c Config) ([]SegmentInfo, error) {
+	bases, err := segmentBases(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]SegmentInfo, 0, len(bases))
+	for i, base := range bases {
+		info, err := inspectSegment(dir, base, c)
+		if err != nil {
+			return nil, err
+		}
+		if i+1 < len(bases) {
+			info.NextOffset = bases[i+1]
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+func inspectSegment(dir string, base uint64, c Config) (SegmentInfo, error) {
+	info := SegmentInfo{BaseOffset: base, NextOffset: base}
+
+	s, err := openSegmentReadOnly(dir, base, c)
+	if err != nil {
+		return info, err
+	}
+	defer s.store.File.Close()
+
+	info.StoreBytes = s.store.size.Load()
+	if stat, err := os.Stat(segmentPath(dir, base, ".index")); err == nil {
+		info.IndexBytes = uint64(stat.Size())
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return info, err
+	}
+
+	info.Err = s.scan(base, 0, func(off, _ uint64, _ frame, record *log_v1.Record) (bool, error) {
+		if record == nil {
+			info.CorruptFrames++
+		}
+		info.Records++
+		info.NextOffset = off + 1
+		return true, nil
+	})
+	if errors.Is(info.Err, errUnknownKey) {
+		return info, info.Err
+	}
+
+	return info, nil
+}
+
+// ReadRecords calls fn with every record of the data directory whose offset is in [from, to), in order. It stops at the
+// first damaged record, returning an ErrCorruptRecord.
+func ReadRecords(dir string, c Config, from, to uint64, fn func(*log_v1.Record) error) error {
+	bases, err := segmentBases(dir)
+	if err != nil {
+		return err
+	}
+
+	for i, base := range bases {
+		if base >= to {
+			break
+		}
+		if i+1 < len(bases) && bases[i+1] <= from {
+			continue
+		}
+
+		if err = readSegmentRecords(dir, base, c, from, to, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readSegmentRecords(dir string, base uint64, c Config, from, to uint64, fn func(*log_v1.Record) error) error {
+	s, err := openSegmentReadOnly(dir, base, c)
+	if err != nil {
+		return err
+	}
+	defer s.store.File.Close()
+
+	return s.scan(base, 0, func(off, pos uint64, _ frame, record *log_v1.Record) (bool, error) {
+		switch {
+		case off >= to:
+			return false, nil
+		case off < from:
+			return true, nil
+		case record == nil:
+			return false, s.corruptErr(off, pos)
+		}
+
+		return true, fn(record)
+	})
+}
+
+// VerifyIndex checks that every entry of the index of the segment points at an undamaged frame starting with the record
+// of the entry offset.
+func VerifyIndex(dir string, c Config, base uint64) ([]IndexError, error) {
+	// a missing index is reported like an empty one
+	entries, err := readIndexEntries(segmentPath(dir, base, ".index"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	s, err := openSegmentReadOnly(dir, base, c)
+	if err != nil {
+		return nil, err
+	}
+	defer s.store.File.Close()
+
+	type found struct {
+		off     uint64
+		damaged bool
+	}
+	frames := make(map[uint64]found)
+	err = s.scanFrames(base, 0, func(off, pos uint64, _ frame, records []*log_v1.Record) (bool, error) {
+		frames[pos] = found{off: off, damaged: records == nil}
+		return true, nil
+	})
+	// the frames after a damaged length prefix cannot be found, so the entries pointing at them are reported as well
+	if _, ok := err.(log_v1.ErrCorruptRecord); err != nil && !ok {
+		return nil, err
+	}
+
+	var problems []IndexError
+	if first, ok := frames[0]; ok && len(entries) == 0 {
+		problems = append(problems, IndexError{BaseOffset: base, Offset: first.off, Reason: "first record is not indexed"})
+	}
+	if len(frames) == 0 && len(entries) == 1 && entries[0] == (indexEntry{}) {
+		// the padding of an empty index
+		entries = nil
+	}
+	for i, e := range entries {
+		problem := IndexError{BaseOffset: base, Entry: uint64(i), Offset: base + uint64(e.off), Position: e.pos}
+		f, ok := frames[e.pos]
+		switch {
+		case i > 0 && e.off <= entries[i-1].off:
+			problem.Reason = "offset is not after the one of the previous entry"
+		case !ok:
+			problem.Reason = "no frame starts at the position"
+		case f.damaged:
+			problem.Reason = "frame is damaged"
+		case f.off != problem.Offset:
+			problem.Reason = fmt.Sprintf("frame holds offset %d", f.off)
+		default:
+			continue
+		}
+		problems = append(problems, problem)
+	}
+
+	return problems, nil
+}
+
+// RebuildIndex replaces the index of the segment with one built from the frames of its store, spacing the entries by
+// the configured IndexIntervalBytes as appends do. It takes the lock of the data directory, so the log must not be
+// running. When the store is damaged, the frames up to the damage are indexed and the damage is returned along with the
+// number of entries written.
+func RebuildIndex(dir string, c Config, base uint64) (uint64, error) {
+	lock, err := lockDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Close()
+
+	s, err := openSegmentReadOnly(dir, base, c)
+	if err != nil {
+		return 0, err
+	}
+	defer s.store.File.Close()
+
+	var (
+		p          []byte
+		indexedPos uint64
+	)
+	interval := c.Segment.IndexIntervalBytes
+	scanErr := s.scanFrames(base, 0, func(off, pos uint64, _ frame, _ []*log_v1.Record) (bool, error) {
+		if interval == 0 || len(p) == 0 || pos-indexedPos >= interval {
+			p = enc.AppendUint32(p, uint32(off-base))
+			p = enc.AppendUint64(p, pos)
+			indexedPos = pos
+		}
+		return true, nil
+	})
+	if errors.Is(scanErr, errUnknownKey) {
+		return 0, scanErr
+	}
+
+	// the index is replaced at once, so a failed rebuild leaves the previous one
+	name := segmentPath(dir, base, ".index")
+	if err = os.WriteFile(name+".tmp", p, 0644); err != nil {
+		return 0, err
+	}
+	if err = os.Rename(name+".tmp", name); err != nil {
+		return 0, err
+	}
+
+	return uint64(len(p)) / entWidth, scanErr
+}
+
+// segmentBases returns the base offsets of the segments of the data directory, in order.
+func segmentBases(dir string) ([]uint64, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var bases []uint64
+	for _, file := range files {
+		off, ext, ok, err := segmentFile(file.Name())
+		if err != nil {
+			return nil, err
+		}
+		if ok && ext == ".store" {
+			bases = append(bases, off)
+		}
+	}
+	slices.Sort(bases)
+
+	return bases, nil
+}
+
+func segmentPath(dir string, base uint64, ext string) string {
+	return path.Join(dir, fmt.Sprintf("%d%s", base, ext))
+}
+
+// openSegmentReadOnly opens the store of the segment without modifying any of its files. The returned segment has no
+// indexes, it is only meant to be scanned.
+func openSegmentReadOnly(dir string, base uint64, c Config) (*segment, error) {
+	f, err := os.Open(segmentPath(dir, base, ".store"))
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := newStore(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &segment{store: st, baseOffset: base, config: c}, nil
+}
+
+type indexEntry struct {
+	off uint32
+	pos uint64
+}
+
+// readIndexEntries reads the entries of the index file up to the last non-zero one, as the file of an index which was
+// not closed is padded with zeros. The first entry is kept, its offset and position are zero for most segments.
+func readIndexEntries(name string) ([]indexEntry, error) {
+	p, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]indexEntry, 0, uint64(len(p))/entWidth)
+	for i := uint64(0); (i+1)*entWidth <= uint64(len(p)); i++ {
+		e := p[i*entWidth:]
+		entries = append(entries, indexEntry{off: enc.Uint32(e), pos: enc.Uint64(e[offWidth:])})
+	}
+	for len(entries) > 1 && entries[len(entries)-1] == (indexEntry{}) {
+		entries = entries[:len(entries)-1]
+	}
+
+	return entries, nil
+}
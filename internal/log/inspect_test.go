@@ -0,0 +1,81 @@
+package log
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+)
+
+func TestInspect(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 3
+	log := newTestLog(t, c)
+	dir := log.Dir
+	appendKeyed(t, log,
+		"a", "1", "b", "1", "a", "2",
+		"c", "1", "b", "2", "", "x",
+		"a", "", "c", "2", "d", "1",
+	)
+	_, err := log.Compact(compactionStart.Add(time.Minute))
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	infos, err := InspectSegments(dir, Config{})
+	require.NoError(t, err)
+	require.Len(t, infos, 4)
+	// the compaction emptied the first segment
+	require.Equal(t, SegmentInfo{BaseOffset: 0, NextOffset: 3}, infos[0])
+	require.Equal(t, uint64(3), infos[1].BaseOffset)
+	require.Equal(t, uint64(6), infos[1].NextOffset)
+	require.Equal(t, uint64(2), infos[1].Records)
+	require.Equal(t, 2*entWidth, infos[1].IndexBytes)
+	require.Equal(t, uint64(9), infos[2].NextOffset)
+	require.Equal(t, SegmentInfo{BaseOffset: 9, NextOffset: 9}, infos[3])
+
+	var offsets []uint64
+	err = ReadRecords(dir, Config{}, 5, 8, func(record *log_v1.Record) error {
+		offsets = append(offsets, record.Offset)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{5, 6, 7}, offsets)
+
+	for _, info := range infos {
+		problems, err := VerifyIndex(dir, Config{}, info.BaseOffset)
+		require.NoError(t, err)
+		require.Empty(t, problems)
+	}
+
+	// point the second entry of the compacted segment at the first record
+	name := segmentPath(dir, 3, ".index")
+	p, err := os.ReadFile(name)
+	require.NoError(t, err)
+	enc.PutUint64(p[entWidth+offWidth:], 0)
+	require.NoError(t, os.WriteFile(name, p, 0644))
+
+	problems, err := VerifyIndex(dir, Config{}, 3)
+	require.NoError(t, err)
+	require.Equal(t, []IndexError{{BaseOffset: 3, Entry: 1, Offset: 5, Position: 0, Reason: "frame holds offset 4"}}, problems)
+
+	// the rebuilt index keeps the offsets skipped by the compaction
+	require.NoError(t, os.Remove(name))
+	n, err := RebuildIndex(dir, Config{}, 3)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), n)
+	problems, err = VerifyIndex(dir, Config{}, 3)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	requireRecord(t, log, 3, 4, "b", "2")
+	requireRecord(t, log, 5, 5, "", "x")
+
+	// the index of a running log is not rebuilt
+	_, err = RebuildIndex(dir, Config{}, 3)
+	require.ErrorIs(t, err, ErrDataDirLocked)
+	require.NoError(t, log.Close())
+}
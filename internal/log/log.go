@@ -1,30 +1,59 @@
 package log
 
 import (
+	"errors"
 	"io"
 	"os"
 	"path"
+	"slices"
 	"sort"
-	"strconv"
-	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	log_v1 "github.com/vlamug/pdlog/api/v1"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
 )
 
 const (
-	defaultMaxStoreBytes = 1024
-	defaultMaxIndexBytes = 1024
+	defaultMaxStoreBytes          = 1024
+	defaultMaxIndexBytes          = 1024
+	defaultTimeIndexIntervalBytes = 4096
 )
 
+// Log is written by a single writer at a time, which holds mu. Readers do not take it: they read the published snapshot
+// of the segments, so appends do not stall them and they do not stall appends.
 type Log struct {
 	mu sync.RWMutex
+	// maintenance serializes the operations rewriting or removing closed segments, it is taken before mu
+	maintenance sync.Mutex
 
 	Dir    string
 	Config Config
 
 	activeSegment *segment
-	segments      []*segment
+	// snapshot holds the current list of segments, it is never modified but replaced by writers
+	snapshot atomic.Pointer[[]*segment]
+
+	// unsyncedRecords and unsyncedBytes count what was appended to the active segment since the last sync
+	unsyncedRecords uint64
+	unsyncedBytes   uint64
+	stats           stats
+	// cache holds the recent records, it is nil when disabled
+	cache *recordCache
+	// tier keeps track of the offloaded segments, it is nil unless tiered storage is enabled
+	tier *tier
+	// lock is the locked lock file of the data directory
+	lock *os.File
+
+	// group is the group committer, it is set while running when group commit is enabled
+	group atomic.Pointer[groupCommit]
+
+	closing chan struct{}
+	wg      sync.WaitGroup
+
+	logger *zap.Logger
 }
 
 func NewLog(dir string, cfg Config) (*Log, error) {
@@ -34,104 +63,509 @@ func NewLog(dir string, cfg Config) (*Log, error) {
 	if cfg.Segment.MaxIndexBytes == 0 {
 		cfg.Segment.MaxIndexBytes = defaultMaxIndexBytes
 	}
+	if cfg.Segment.TimeIndexIntervalBytes == 0 {
+		cfg.Segment.TimeIndexIntervalBytes = defaultTimeIndexIntervalBytes
+	}
+	if cfg.Durability.Interval == 0 {
+		cfg.Durability.Interval = defaultSyncInterval
+	}
+	if cfg.Retention.CheckInterval == 0 {
+		cfg.Retention.CheckInterval = defaultRetentionCheckInterval
+	}
+	if cfg.Compaction.Interval == 0 {
+		cfg.Compaction.Interval = defaultCompactionInterval
+	}
+	if cfg.Compaction.TombstoneRetention == 0 {
+		cfg.Compaction.TombstoneRetention = defaultTombstoneRetention
+	}
+	if cfg.Tiering.Interval == 0 {
+		cfg.Tiering.Interval = defaultOffloadInterval
+	}
+	if cfg.Tiering.CachedSegments == 0 {
+		cfg.Tiering.CachedSegments = defaultCachedSegments
+	}
 
 	l := &Log{
 		Dir:    dir,
 		Config: cfg,
+		logger: zap.L().Named("log"),
 	}
+	if cfg.Cache.MaxBytes != 0 {
+		l.cache = newRecordCache(cfg.Cache.MaxBytes)
+	}
+
+	if err := l.setup(); err != nil {
+		l.unlock()
+		return nil, err
+	}
+	l.start()
 
-	return l, l.setup()
+	return l, nil
 }
 
 func (l *Log) setup() error {
-	files, err := os.ReadDir(l.Dir)
+	l.activeSegment = nil
+	l.publish(nil)
+	l.cache.clear()
+
+	if err := os.MkdirAll(l.Dir, 0755); err != nil {
+		return err
+	}
+
+	var err error
+	if l.lock, err = lockDir(l.Dir); err != nil {
+		return err
+	}
+	if err = l.checkManifest(); err != nil {
+		return err
+	}
+
+	if l.Config.Tiering.Store != nil {
+		if l.tier, err = newTier(path.Join(l.Dir, remoteDir), l.Config); err != nil {
+			return err
+		}
+	}
+
+	// a segment rewritten by compaction or a restored snapshot is either complete and waiting to replace the original
+	// segments or to be discarded
+	if err := finishSwap(l.Dir); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(path.Join(l.Dir, cleanedDir)); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(path.Join(l.Dir, restoreDir)); err != nil {
+		return err
+	}
+
+	segments, err := l.openSegments()
 	if err != nil {
 		return err
 	}
+	l.publish(segments)
+	l.activeSegment = segments[len(segments)-1]
+
+	return l.recover()
+}
+
+// openSegments opens the segments found in the data directory, or a first one at the initial offset when there are
+// none.
+func (l *Log) openSegments() ([]*segment, error) {
+	files, err := os.ReadDir(l.Dir)
+	if err != nil {
+		return nil, err
+	}
 
+	// every segment has a store along with its index files, so the stores alone tell the base offsets
 	var baseOffsets []uint64
 	for _, file := range files {
-		offStr := strings.TrimSuffix(file.Name(), path.Ext(file.Name()))
-		off, _ := strconv.ParseUint(offStr, 10, 0)
-		baseOffsets = append(baseOffsets, off)
+		if slices.Contains(dataDirEntries, file.Name()) {
+			continue
+		}
+
+		off, ext, ok, err := segmentFile(file.Name())
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			l.logger.Warn("skipping unknown file in data directory", zap.String("file", file.Name()))
+			continue
+		}
+
+		if ext == ".store" {
+			baseOffsets = append(baseOffsets, off)
+		}
 	}
 
 	sort.Slice(baseOffsets, func(i, j int) bool {
 		return baseOffsets[i] < baseOffsets[j]
 	})
+	if len(baseOffsets) == 0 {
+		baseOffsets = []uint64{l.Config.Segment.InitialOffset}
+	}
 
-	for i := 0; i < len(baseOffsets); i++ {
-		if err = l.newSegment(baseOffsets[i]); err != nil {
-			return err
+	segments := make([]*segment, 0, len(baseOffsets))
+	for _, off := range baseOffsets {
+		s, err := newSegment(l.Dir, off, l.Config)
+		if err != nil {
+			return nil, errors.Join(err, closeSegments(segments))
 		}
-		// baseOffset contains dup for index and store, so we skip the dup
-		i++
+		segments = append(segments, s)
 	}
 
-	if l.segments == nil {
-		if err = l.newSegment(l.Config.Segment.InitialOffset); err != nil {
-			return err
+	// the last records of a compacted segment may be gone, the next segment tells where its offsets end
+	for i := 0; i < len(segments)-1; i++ {
+		segments[i].nextOffset.Store(segments[i+1].baseOffset)
+	}
+
+	return segments, nil
+}
+
+// closeSegments closes all the segments, whatever the errors.
+func closeSegments(segments []*segment) error {
+	var errs []error
+	for _, seg := range segments {
+		errs = append(errs, seg.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// recover repairs the segments after an unclean shutdown. The active segment is the only one written to and thus the
+// only one which could be left with a torn write or a stale index. The closed ones may only be left with the zeros
+// preallocated at the end of their index.
+func (l *Log) recover() error {
+	segments := l.segments()
+	for _, seg := range segments[:len(segments)-1] {
+		if n := seg.trimIndex(); n != 0 {
+			l.logger.Warn(
+				"trimmed index of closed segment",
+				zap.Uint64("base_offset", seg.baseOffset),
+				zap.Uint64("next_offset", seg.nextOffset.Load()),
+				zap.Uint64("dropped_index_entries", n),
+			)
 		}
 	}
 
+	r, err := l.activeSegment.recover()
+	if err != nil {
+		return err
+	}
+
+	if r.changed() {
+		l.logger.Warn(
+			"recovered active segment",
+			zap.Uint64("base_offset", l.activeSegment.baseOffset),
+			zap.Uint64("next_offset", l.activeSegment.nextOffset.Load()),
+			zap.Uint64("truncated_bytes", r.truncatedBytes),
+			zap.Uint64("dropped_index_entries", r.droppedEntries),
+			zap.Uint64("rebuilt_index_entries", r.rebuiltEntries),
+			zap.Uint64("corrupt_frames", r.corruptFrames),
+		)
+	}
+
+	if l.activeSegment.IsMaxed() {
+		return l.newSegment(l.activeSegment.nextOffset.Load())
+	}
+
 	return nil
 }
 
+// Append appends the record to the active segment and returns its offset. Appends are serialized, but they do not
+// block readers: the record is published once it can be read. With group commit, the record is written along with the
+// ones appended concurrently. A record appended but not synced as the durability policy requires is returned with its
+// offset and ErrNotDurable.
 func (l *Log) Append(record *log_v1.Record) (uint64, error) {
-	// @todo use locks inside segment not log
+	if g := l.group.Load(); g != nil {
+		if res, ok := g.submit(record); ok {
+			return res.off, res.err
+		}
+	}
+
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	if err := l.rollIfOld(time.Now()); err != nil {
+		return 0, err
+	}
+
+	size := l.activeSegment.store.size.Load()
 	off, err := l.activeSegment.Append(record)
 	if err != nil {
 		return 0, err
 	}
 
+	// the record is appended even when it could not be synced
+	err = l.appended([]*log_v1.Record{record}, l.activeSegment.store.size.Load()-size)
 	if l.activeSegment.IsMaxed() {
-		err = l.newSegment(off + 1)
+		err = errors.Join(err, l.newSegment(off+1))
 	}
 
 	return off, err
 }
 
+// AppendBatch appends the records under consecutive offsets into a single segment and returns their offsets. The active
+// segment is rolled beforehand if the batch does not fit in it, a batch which would not fit in an empty segment either,
+// by its store bytes or by the index entries it needs, fails with ErrBatchTooLarge. Either all the records are appended
+// or none, records appended but not synced as the durability policy requires are returned with their offsets and
+// ErrNotDurable.
+func (l *Log) AppendBatch(records []*log_v1.Record) ([]uint64, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// a single record too big for any segment goes alone in its own, as with Append
+	next := l.activeSegment.nextOffset.Load()
+	if len(records) > 1 && fitting(records, next, 0, 0, 0, l.Config) != len(records) {
+		return nil, log_v1.ErrBatchTooLarge{Records: len(records)}
+	}
+
+	if err := l.rollIfOld(time.Now()); err != nil {
+		return nil, err
+	}
+
+	if !l.activeSegment.fits(records) && l.activeSegment.nextOffset.Load() != l.activeSegment.baseOffset {
+		if err := l.newSegment(l.activeSegment.nextOffset.Load()); err != nil {
+			return nil, err
+		}
+	}
+
+	size := l.activeSegment.store.size.Load()
+	first, err := l.activeSegment.AppendBatch(records)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make([]uint64, len(records))
+	for i := range offsets {
+		offsets[i] = first + uint64(i)
+	}
+
+	// the records are appended even when they could not be synced
+	err = l.appended(records, l.activeSegment.store.size.Load()-size)
+	if l.activeSegment.IsMaxed() {
+		err = errors.Join(err, l.newSegment(l.activeSegment.nextOffset.Load()))
+	}
+
+	return offsets, err
+}
+
+// appended updates the counters once the records were written to the active segment, n being the number of bytes the
+// store grew by, and syncs the segment if the durability policy requires so. The caller must hold the lock.
+func (l *Log) appended(records []*log_v1.Record, n uint64) error {
+	l.account(records, n)
+	return l.maybeSync(uint64(len(records)), n)
+}
+
+// account updates the compression counters once the records were written with a single segment append, n being the
+// number of bytes the store grew by, and caches the records. The cache gets copies, as the caller may reuse the records.
+func (l *Log) account(records []*log_v1.Record, n uint64) {
+	var raw uint64
+	for _, record := range records {
+		raw += uint64(proto.Size(record))
+		if l.cache != nil {
+			l.cache.add(proto.Clone(record).(*log_v1.Record))
+		}
+	}
+
+	// the records are stored in a single frame
+	l.stats.observeAppend(raw, n-(lenWidth+crcWidth))
+}
+
+// Read returns the record stored under the offset. When compaction removed it, the first record after it is returned
+// instead, so the caller has to look at the offset of the record. Read takes no log lock. With the cache enabled, the
+// returned record may be shared with other readers and must not be modified.
 func (l *Log) Read(off uint64) (*log_v1.Record, error) {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
+	if record, ok := l.cached(off); ok {
+		return record, nil
+	}
+
+	// the segments are replaced before the cache is cleared, records read from the replaced ones are not cached
+	generation := l.cache.generation()
+	for {
+		snapshot := l.snapshot.Load()
+		from := off
+		// offsets below the local segments are read from the offloaded ones
+		if lowest := (*snapshot)[0].baseOffset; l.tier != nil && off < lowest {
+			record, err := l.tier.read(off, lowest)
+			if err != io.EOF {
+				return record, err
+			}
+			from = lowest
+		}
+
+		record, err := read(*snapshot, from)
+		// the segment was removed or replaced after the snapshot was taken, the new snapshot tells where to read
+		if errors.Is(err, errSegmentClosed) && l.snapshot.Load() != snapshot {
+			continue
+		}
+
+		if err == nil {
+			l.cache.addRead(generation, record)
+		}
+
+		return record, err
+	}
+}
+
+// cached returns the record stored under the offset if it is in the cache. Offsets below the lowest one are never
+// served from the cache, so a record cached while its segment was being removed is not read back.
+func (l *Log) cached(off uint64) (*log_v1.Record, bool) {
+	if l.cache == nil || off < l.segments()[0].baseOffset {
+		return nil, false
+	}
+
+	record, ok := l.cache.get(off)
+	l.stats.observeCache(ok)
+
+	return record, ok
+}
+
+// read reads the offset from the given segments.
+func read(segments []*segment, off uint64) (*log_v1.Record, error) {
+	if lowest := segments[0].baseOffset; off < lowest {
+		return nil, log_v1.ErrOffsetOutOfRange{Offset: off, Lowest: lowest}
+	}
+
+	// start with the last segment beginning at or before the offset
+	i := sort.Search(len(segments), func(i int) bool {
+		return segments[i].baseOffset > off
+	}) - 1
+
+	for _, seg := range segments[i:] {
+		if seg.nextOffset.Load() <= off {
+			continue
+		}
+
+		record, err := seg.Read(max(off, seg.baseOffset))
+		if err == io.EOF {
+			// the rest of the segment was compacted away
+			continue
+		}
+
+		return record, err
+	}
+
+	return nil, log_v1.ErrOffsetOutOfRange{Offset: off}
+}
+
+// ReadRange returns the stored frames of the records from the first one at or after from, within maxBytes though at
+// least one frame is returned, along with the offsets of the first record at or after from and of the one following
+// the last. The frames are copied as they are stored, spanning segments, without decoding the records: a Decoder reads
+// them back. A batch frame is returned whole, so the records before the first offset are to be skipped. ReadRange
+// takes no log lock.
+func (l *Log) ReadRange(from uint64, maxBytes int) (frames []byte, first, next uint64, err error) {
+	for {
+		snapshot := l.snapshot.Load()
+		if lowest := (*snapshot)[0].baseOffset; l.tier != nil && from < lowest {
+			frames, first, next, err = l.tier.readRange(from, lowest, maxBytes)
+			if err != io.EOF {
+				return frames, first, next, err
+			}
+			from = lowest
+		}
+
+		frames, first, next, err = readRange(*snapshot, from, maxBytes)
+		// the segment was removed or replaced after the snapshot was taken, the new snapshot tells where to read
+		if errors.Is(err, errSegmentClosed) && l.snapshot.Load() != snapshot {
+			continue
+		}
+
+		return frames, first, next, err
+	}
+}
+
+// readRange reads the frames from the given segments.
+func readRange(segments []*segment, from uint64, maxBytes int) ([]byte, uint64, uint64, error) {
+	if lowest := segments[0].baseOffset; from < lowest {
+		return nil, 0, 0, log_v1.ErrOffsetOutOfRange{Offset: from, Lowest: lowest}
+	}
+
+	i := sort.Search(len(segments), func(i int) bool {
+		return segments[i].baseOffset > from
+	}) - 1
+
+	var (
+		frames      []byte
+		first, next uint64
+	)
+	off := from
+	for _, seg := range segments[i:] {
+		if seg.nextOffset.Load() <= off {
+			continue
+		}
+
+		p, cur, n, err := seg.readRange(frames, max(off, seg.baseOffset), maxBytes)
+		switch {
+		case err == io.EOF:
+			continue
+		case err != nil && len(frames) > 0:
+			// the frames read so far are returned, the next read reports the error
+			return frames, first, next, nil
+		case err != nil:
+			return nil, 0, 0, err
+		case len(p) == len(frames):
+			return frames, first, next, nil
+		}
+
+		if len(frames) == 0 {
+			first = cur
+		}
+		frames, next, off = p, n, n
+	}
+
+	if len(frames) == 0 {
+		return nil, 0, 0, log_v1.ErrOffsetOutOfRange{Offset: from}
+	}
 
-	var foundSeg *segment
-	for _, seg := range l.segments {
-		if seg.baseOffset <= off && off < seg.nextOffset {
-			foundSeg = seg
-			break
+	return frames, first, next, nil
+}
+
+// OffsetForTime returns the offset of the first record whose timestamp is at or after t. When there is no such record,
+// it returns the offset the next appended record gets.
+func (l *Log) OffsetForTime(t time.Time) (uint64, error) {
+	timestamp := t.UnixNano()
+	if l.tier != nil {
+		// the offloaded segments hold the records older than the local ones
+		off, err := l.tier.offsetForTime(timestamp, l.segments()[0].baseOffset)
+		if err != io.EOF {
+			return off, err
 		}
 	}
 
-	if foundSeg == nil || foundSeg.nextOffset <= off {
-		return nil, log_v1.ErrOffsetOutOfRange{Offset: off}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	segments := l.segments()
+	i := sort.Search(len(segments), func(i int) bool {
+		return segments[i].maxTimestamp >= timestamp
+	})
+	if i == len(segments) {
+		return l.activeSegment.nextOffset.Load(), nil
 	}
 
-	return foundSeg.Read(off)
+	return segments[i].offsetForTime(timestamp)
 }
 
 func (l *Log) Close() error {
+	l.stop()
+
+	l.maintenance.Lock()
+	defer l.maintenance.Unlock()
+
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	defer l.unlock()
 
-	for _, seg := range l.segments {
+	for _, seg := range l.segments() {
 		if err := seg.Close(); err != nil {
 			// @todo do we have to close all segments despite the error
 			return err
 		}
 	}
 
+	if l.tier != nil {
+		return l.tier.close()
+	}
+
 	return nil
 }
 
+// Remove closes the log and deletes its segments, the offloaded ones included.
 func (l *Log) Remove() error {
 	if err := l.Close(); err != nil {
 		return err
 	}
 
+	if l.tier != nil {
+		if err := l.tier.removeAll(); err != nil {
+			return err
+		}
+	}
+
 	return os.RemoveAll(l.Dir)
 }
 
@@ -140,21 +574,33 @@ func (l *Log) Reset() error {
 		return err
 	}
 
-	return l.setup()
+	if err := l.setup(); err != nil {
+		return err
+	}
+	l.start()
+
+	return nil
+}
+
+// Stats returns the current values of the log counters.
+func (l *Log) Stats() Stats {
+	return l.stats.snapshot()
 }
 
 func (l *Log) LowestOffset() (uint64, error) {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
+	lowest := l.segments()[0].baseOffset
+	if l.tier != nil {
+		if off, ok := l.tier.lowest(); ok {
+			lowest = min(lowest, off)
+		}
+	}
 
-	return l.segments[0].baseOffset, nil
+	return lowest, nil
 }
 
 func (l *Log) HighestOffset() (uint64, error) {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
-
-	off := l.segments[len(l.segments)-1].nextOffset
+	segments := l.segments()
+	off := segments[len(segments)-1].nextOffset.Load()
 	if off == 0 {
 		return 0, nil
 	}
@@ -163,50 +609,134 @@ func (l *Log) HighestOffset() (uint64, error) {
 }
 
 func (l *Log) Truncate(lowest uint64) error {
+	l.maintenance.Lock()
+	defer l.maintenance.Unlock()
+
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	var segments []*segment
-	for _, seg := range l.segments {
-		if seg.nextOffset <= lowest+1 {
-			if err := seg.Remove(); err != nil {
-				return err
-			}
+	var segments, removed []*segment
+	for _, seg := range l.segments() {
+		if seg.nextOffset.Load() <= lowest+1 {
+			removed = append(removed, seg)
 			continue
 		}
 
 		segments = append(segments, seg)
 	}
 
-	l.segments = segments
+	if l.tier != nil {
+		if err := l.tier.truncate(lowest, l.segments()[0].baseOffset); err != nil {
+			return err
+		}
+	}
+
+	// readers still holding the removed segments are waited for by Remove
+	l.publish(segments)
+	l.cache.clear()
+	for _, seg := range removed {
+		if err := seg.Remove(); err != nil {
+			return err
+		}
+		if l.tier != nil {
+			if err := l.tier.remove(seg.baseOffset); err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
 func (l *Log) Reader() io.Reader {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
-
-	readers := make([]io.Reader, len(l.segments))
-	for i, seg := range l.segments {
+	segments := l.segments()
+	readers := make([]io.Reader, len(segments))
+	for i, seg := range segments {
 		readers[i] = &originReader{seg.store, 0}
 	}
 
 	return io.MultiReader(readers...)
 }
 
-// @todo do we need to use locks?
+// newSegment rolls the log over to a new active segment starting at the offset. The caller must hold the lock.
 func (l *Log) newSegment(off uint64) error {
+	// records of the rolled segment must be as durable as the policy promises, only the active one is synced later
+	if l.activeSegment != nil && l.Config.Durability.Policy != SyncOnClose {
+		if err := l.sync(); err != nil {
+			return err
+		}
+	}
+
 	s, err := newSegment(l.Dir, off, l.Config)
 	if err != nil {
 		return err
 	}
 
-	l.segments = append(l.segments, s)
+	l.publish(append(slices.Clip(l.segments()), s))
 	l.activeSegment = s
 
 	return nil
 }
 
+// segments returns the current snapshot of the segments, which must not be modified.
+func (l *Log) segments() []*segment {
+	return *l.snapshot.Load()
+}
+
+// publish replaces the snapshot of the segments seen by readers. The caller must hold the lock.
+func (l *Log) publish(segments []*segment) {
+	l.snapshot.Store(&segments)
+}
+
+// start runs the background goroutines required by the configuration.
+func (l *Log) start() {
+	l.closing = make(chan struct{})
+
+	if l.Config.Durability.Policy == SyncInterval {
+		l.wg.Add(1)
+		go l.syncLoop(l.closing)
+	}
+
+	if l.Config.Compaction.Enabled {
+		l.wg.Add(1)
+		go l.compactLoop(l.closing)
+	}
+
+	if l.Config.Segment.MaxAge != 0 {
+		l.wg.Add(1)
+		go l.rollLoop(l.closing)
+	}
+
+	if l.Config.Retention.MaxAge != 0 || l.Config.Retention.MaxBytes != 0 {
+		l.wg.Add(1)
+		go l.retentionLoop(l.closing)
+	}
+
+	if l.tier != nil {
+		l.wg.Add(1)
+		go l.offloadLoop(l.closing)
+	}
+
+	if l.Config.GroupCommit.Window != 0 {
+		g := newGroupCommit(l.closing)
+		l.group.Store(g)
+		l.wg.Add(1)
+		go l.commitLoop(g)
+	}
+}
+
+// stop terminates the background goroutines and waits for them to return.
+func (l *Log) stop() {
+	if l.closing == nil {
+		return
+	}
+
+	l.group.Store(nil)
+	close(l.closing)
+	l.closing = nil
+	l.wg.Wait()
+}
+
 type originReader struct {
 	*store
 	off int64
@@ -0,0 +1,107 @@
+package log
+
+import (
+	"os"
+	"sync/atomic"
+	"testing"
+
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+)
+
+const benchRecords = 10000
+
+func BenchmarkAppend(b *testing.B) {
+	log := newBenchLog(b, 0)
+	record := &log_v1.Record{Value: []byte("hello world")}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := log.Append(record); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	log := newBenchLog(b, benchRecords)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var off uint64
+		for pb.Next() {
+			if _, err := log.Read(off % benchRecords); err != nil {
+				b.Error(err)
+				return
+			}
+			off += 7
+		}
+	})
+}
+
+// BenchmarkReadUnderAppendLoad measures the read latency while a producer appends to the log as fast as it can.
+func BenchmarkReadUnderAppendLoad(b *testing.B) {
+	log := newBenchLog(b, benchRecords)
+
+	var appends atomic.Uint64
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			if _, err := log.Append(&log_v1.Record{Value: []byte("hello world")}); err != nil {
+				b.Error(err)
+				return
+			}
+			appends.Add(1)
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var off uint64
+		for pb.Next() {
+			if _, err := log.Read(off % benchRecords); err != nil {
+				b.Error(err)
+				return
+			}
+			off += 7
+		}
+	})
+	b.StopTimer()
+
+	close(stop)
+	<-done
+	b.ReportMetric(float64(appends.Load())/b.Elapsed().Seconds(), "appends/s")
+}
+
+func newBenchLog(b *testing.B, records int) *Log {
+	b.Helper()
+
+	dir, err := os.MkdirTemp("", "log-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { os.RemoveAll(dir) })
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1 << 20
+	c.Segment.MaxIndexBytes = entWidth * 4096
+	log, err := NewLog(dir, c)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { log.Close() })
+
+	for i := 0; i < records; i++ {
+		if _, err := log.Append(&log_v1.Record{Value: []byte("hello world")}); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	return log
+}
@@ -1,12 +1,18 @@
 package log
 
 import (
-	log_v1 "github.com/vlamug/pdlog/api/v1"
-	"google.golang.org/protobuf/proto"
+	"bytes"
+	"fmt"
 	"io"
 	"os"
+	"sync"
 	"testing"
+	"time"
+
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+	"google.golang.org/protobuf/proto"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -17,6 +23,12 @@ func TestLog(t *testing.T) {
 		"init with existing segments":       testInitExisting,
 		"reader":                            testReader,
 		"truncate":                          testTruncate,
+		"corrupted record":                  testCorruptedRecord,
+		"append batch":                      testAppendBatch,
+		"append batch too large":            testAppendBatchTooLarge,
+		"mixed compression codecs":          testMixedCodecs,
+		"offset for time":                   testOffsetForTime,
+		"read range":                        testReadRange,
 	} {
 		t.Run(scenario, func(t *testing.T) {
 			dir, err := os.MkdirTemp("", "store-test")
@@ -96,7 +108,7 @@ func testReader(t *testing.T, log *Log) {
 	require.NoError(t, err)
 
 	read := &log_v1.Record{}
-	err = proto.Unmarshal(b[lenWidth:], read)
+	err = proto.Unmarshal(b[lenWidth+crcWidth:], read)
 	require.Equal(t, record.Value, read.Value)
 }
 
@@ -116,3 +128,462 @@ func testTruncate(t *testing.T, log *Log) {
 	_, err = log.Read(0)
 	require.Error(t, err)
 }
+
+func testCorruptedRecord(t *testing.T, log *Log) {
+	record := &log_v1.Record{
+		Value: []byte("hello world"),
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := log.Append(record)
+		require.NoError(t, err)
+	}
+
+	seg := log.segments()[len(log.segments())-2]
+	_, pos, err := seg.index.Read(int64(1 - seg.baseOffset))
+	require.NoError(t, err)
+	require.NoError(t, seg.store.buf.Flush())
+
+	// flip a bit in the middle of the second record's payload
+	f, err := os.OpenFile(seg.store.Name(), os.O_RDWR, 0644)
+	require.NoError(t, err)
+	defer f.Close()
+
+	at := int64(pos + lenWidth + crcWidth + 2)
+	b := make([]byte, 1)
+	_, err = f.ReadAt(b, at)
+	require.NoError(t, err)
+	b[0] ^= 1
+	_, err = f.WriteAt(b, at)
+	require.NoError(t, err)
+
+	_, err = log.Read(0)
+	require.NoError(t, err)
+
+	_, err = log.Read(1)
+	apiErr, ok := err.(log_v1.ErrCorruptRecord)
+	require.True(t, ok, err)
+	require.Equal(t, uint64(1), apiErr.Offset)
+	require.Equal(t, seg.baseOffset, apiErr.BaseOffset)
+	require.Equal(t, pos, apiErr.Position)
+}
+
+func testAppendBatch(t *testing.T, log *Log) {
+	// the segments are made large enough for the batch
+	require.NoError(t, log.Close())
+	c := log.Config
+	c.Segment.MaxStoreBytes = 64
+	log, err := NewLog(log.Dir, c)
+	require.NoError(t, err)
+
+	_, err = log.Append(&log_v1.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+
+	// the batch does not fit in what is left of the active segment, so it goes to a new one
+	batch := []*log_v1.Record{
+		{Value: []byte("first")},
+		{Value: []byte("second")},
+	}
+	offsets, err := log.AppendBatch(batch)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 2}, offsets)
+	require.Len(t, log.segments(), 2)
+	require.Equal(t, uint64(1), log.segments()[1].baseOffset)
+	require.Equal(t, uint64(3), log.segments()[1].nextOffset.Load())
+
+	for i, off := range offsets {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, batch[i].Value, read.Value)
+	}
+}
+
+func testAppendBatchTooLarge(t *testing.T, log *Log) {
+	batch := make([]*log_v1.Record, log.Config.Segment.MaxIndexBytes/entWidth+1)
+	for i := range batch {
+		batch[i] = &log_v1.Record{Value: []byte("hello world")}
+	}
+
+	_, err := log.AppendBatch(batch)
+	require.Equal(t, log_v1.ErrBatchTooLarge{Records: len(batch)}, err)
+
+	off, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+	_, err = log.Read(0)
+	require.Error(t, err)
+}
+
+func TestAppendBatchLimits(t *testing.T) {
+	hello := func(n int) []*log_v1.Record {
+		batch := make([]*log_v1.Record, n)
+		for i := range batch {
+			batch[i] = &log_v1.Record{Value: []byte("hello world")}
+		}
+		return batch
+	}
+
+	for scenario, tc := range map[string]struct {
+		configure func(c *Config)
+		fits      int
+		tooLarge  int
+	}{
+		"store bytes": {
+			configure: func(c *Config) {
+				c.Segment.MaxStoreBytes = 200
+			},
+			fits:     7,
+			tooLarge: 8,
+		},
+		"index entries": {
+			// a batch frame takes a single index entry
+			configure: func(c *Config) {
+				c.Segment.MaxStoreBytes = 200
+				c.Segment.MaxIndexBytes = entWidth
+			},
+			fits:     7,
+			tooLarge: 8,
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			c := Config{}
+			tc.configure(&c)
+			log := newTestLog(t, c)
+			defer log.Close()
+
+			_, err := log.AppendBatch(hello(tc.tooLarge))
+			require.Equal(t, log_v1.ErrBatchTooLarge{Records: tc.tooLarge}, err)
+			_, err = log.Read(0)
+			require.Error(t, err)
+
+			offsets, err := log.AppendBatch(hello(tc.fits))
+			require.NoError(t, err)
+			require.Len(t, offsets, tc.fits)
+			require.Equal(t, uint64(tc.fits), log.segments()[0].nextOffset.Load())
+		})
+	}
+}
+
+func TestBatchCompression(t *testing.T) {
+	events := func() []*log_v1.Record {
+		records := make([]*log_v1.Record, 8)
+		for i := range records {
+			records[i] = &log_v1.Record{
+				Key:   []byte(fmt.Sprintf("user-%d", i)),
+				Value: []byte(fmt.Sprintf(`{"user": "user-%d", "event": "page_view", "path": "/products/%d"}`, i, i)),
+			}
+		}
+		return records
+	}
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 4096
+	c.Compression.Codec = CodecGzip
+
+	single := newTestLog(t, c)
+	defer single.Close()
+	for _, record := range events() {
+		_, err := single.Append(record)
+		require.NoError(t, err)
+	}
+
+	log := newTestLog(t, c)
+	_, err := log.AppendBatch(events())
+	require.NoError(t, err)
+
+	// the batch is a single frame, compressed while its records are too small to compress one by one
+	seg := log.activeSegment
+	f, err := seg.store.readFrame(0)
+	require.NoError(t, err)
+	require.NotZero(t, f.attrs&attrBatch)
+	require.Equal(t, byte(CodecGzip), f.attrs&attrCodecMask)
+	require.Equal(t, seg.store.size.Load(), f.width)
+	require.Equal(t, entWidth, seg.index.size.Load())
+	require.Equal(t, 1.0, single.Stats().CompressionRatio())
+	require.Greater(t, log.Stats().CompressionRatio(), 1.5)
+
+	check := func(log *Log) {
+		t.Helper()
+
+		want := events()
+		for off := uint64(0); off < 8; off++ {
+			record, err := log.Read(off)
+			require.NoError(t, err)
+			require.Equal(t, off, record.Offset)
+			require.Equal(t, want[off].Value, record.Value)
+		}
+		require.Equal(t, []uint64{0, 1, 2, 3, 4, 5, 6, 7}, decodeOffsets(t, readAll(t, log.Reader())))
+
+		// the frame is read whole from the middle of the batch
+		frames, first, next, err := log.ReadRange(3, 1<<20)
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), first)
+		require.Equal(t, uint64(8), next)
+		require.Equal(t, []uint64{0, 1, 2, 3, 4, 5, 6, 7}, decodeOffsets(t, frames))
+	}
+	check(log)
+
+	require.NoError(t, log.Close())
+	log, err = NewLog(log.Dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	check(log)
+
+	off, err := log.Append(&log_v1.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(8), off)
+}
+
+// newTestLog opens a log with the configuration in a directory removed once the test is done.
+func newTestLog(t *testing.T, c Config) *Log {
+	t.Helper()
+
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+
+	return log
+}
+
+func readAll(t *testing.T, r io.Reader) []byte {
+	t.Helper()
+
+	p, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	return p
+}
+
+func testMixedCodecs(t *testing.T, log *Log) {
+	value := bytes.Repeat([]byte("hello world "), 64)
+
+	c := log.Config
+	c.Segment.MaxStoreBytes = 1024
+	for _, codec := range []Codec{CodecGzip, CodecSnappy, CodecNone} {
+		require.NoError(t, log.Close())
+
+		c.Compression.Codec = codec
+		var err error
+		log, err = NewLog(log.Dir, c)
+		require.NoError(t, err)
+
+		_, err = log.Append(&log_v1.Record{Value: value})
+		require.NoError(t, err)
+		if codec != CodecNone {
+			require.Greater(t, log.Stats().CompressionRatio(), 1.0)
+		}
+	}
+	require.Equal(t, 1.0, log.Stats().CompressionRatio())
+
+	for off := uint64(0); off < 3; off++ {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, value, read.Value)
+	}
+
+	dec := NewDecoder(log.Reader())
+	for off := uint64(0); off < 3; off++ {
+		read, err := dec.Decode()
+		require.NoError(t, err)
+		require.Equal(t, off, read.Offset)
+		require.Equal(t, value, read.Value)
+	}
+	_, err := dec.Decode()
+	require.Equal(t, io.EOF, err)
+}
+
+func testOffsetForTime(t *testing.T, log *Log) {
+	start := time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		_, err := log.Append(&log_v1.Record{
+			Value:     []byte("hello world"),
+			Timestamp: start.Add(time.Duration(i) * time.Minute).UnixNano(),
+		})
+		require.NoError(t, err)
+	}
+
+	check := func(log *Log) {
+		for _, tc := range []struct {
+			at   time.Time
+			want uint64
+		}{
+			{at: start.Add(-time.Hour), want: 0},
+			{at: start, want: 0},
+			{at: start.Add(90 * time.Second), want: 2},
+			{at: start.Add(5 * time.Minute), want: 5},
+			{at: start.Add(time.Hour), want: 6},
+		} {
+			off, err := log.OffsetForTime(tc.at)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, off, tc.at)
+		}
+	}
+
+	check(log)
+
+	require.NoError(t, log.Close())
+	log, err := NewLog(log.Dir, log.Config)
+	require.NoError(t, err)
+	check(log)
+}
+
+func testReadRange(t *testing.T, log *Log) {
+	for i := 0; i < 4; i++ {
+		_, err := log.Append(&log_v1.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.Len(t, log.segments(), 5)
+
+	// the range spans the segments
+	frames, first, next, err := log.ReadRange(0, 1<<20)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), first)
+	require.Equal(t, uint64(4), next)
+	require.Equal(t, []uint64{0, 1, 2, 3}, decodeOffsets(t, frames))
+
+	// past the first one, which omits its zero offset, the records have the same size and so are the frames
+	frames, _, _, err = log.ReadRange(1, 1<<20)
+	require.NoError(t, err)
+	width := len(frames) / 3
+	frames, first, next, err = log.ReadRange(1, 2*width+1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), first)
+	require.Equal(t, uint64(3), next)
+	require.Equal(t, []uint64{1, 2}, decodeOffsets(t, frames))
+
+	// a frame bigger than maxBytes is still returned
+	frames, _, next, err = log.ReadRange(3, 1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), next)
+	require.Len(t, frames, width)
+
+	_, _, _, err = log.ReadRange(4, 1<<20)
+	require.ErrorAs(t, err, &log_v1.ErrOffsetOutOfRange{})
+}
+
+// decodeOffsets returns the offsets of the records held by the frames.
+func decodeOffsets(t *testing.T, frames []byte) []uint64 {
+	t.Helper()
+
+	var offsets []uint64
+	dec := NewDecoder(bytes.NewReader(frames))
+	for {
+		record, err := dec.Decode()
+		if err == io.EOF {
+			return offsets
+		}
+		require.NoError(t, err)
+		offsets = append(offsets, record.Offset)
+	}
+}
+
+func TestSparseIndex(t *testing.T) {
+	dir, err := os.MkdirTemp("", "sparse-index-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// the first segment is written with a dense index, the others with a sparse one
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	c.Segment.MaxIndexBytes = entWidth * 8
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	appendRecords(t, log, 4)
+	require.NoError(t, log.Close())
+
+	c.Segment.IndexIntervalBytes = 128
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	appendRecords(t, log, 60)
+
+	// dense segments would have rolled on the index limit every 8 records
+	require.Less(t, len(log.segments()), 60/8)
+	for _, seg := range log.segments()[1:] {
+		require.Less(t, seg.index.size.Load()/entWidth, seg.nextOffset.Load()-seg.baseOffset)
+	}
+
+	check := func(log *Log) {
+		for off := uint64(0); off < 64; off++ {
+			record, err := log.Read(off)
+			require.NoError(t, err)
+			require.Equal(t, off, record.Offset)
+		}
+
+		_, err := log.Read(64)
+		require.IsType(t, log_v1.ErrOffsetOutOfRange{}, err)
+	}
+
+	check(log)
+
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	check(log)
+	off, err := log.Append(&log_v1.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(64), off)
+}
+
+func TestLogConcurrentReadAppend(t *testing.T) {
+	dir, err := os.MkdirTemp("", "concurrent-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 16
+	c.Retention.MaxBytes = 16 * 1024
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	const records = 2000
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < records; i++ {
+			_, err := log.Append(&log_v1.Record{Value: []byte("hello world")})
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			// old segments are removed under the readers
+			if i%100 == 0 {
+				_, err = log.ApplyRetention(time.Now())
+				if !assert.NoError(t, err) {
+					return
+				}
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				lowest, _ := log.LowestOffset()
+				highest, _ := log.HighestOffset()
+				for off := lowest; off <= highest; off += 13 {
+					record, err := log.Read(off)
+					if _, ok := err.(log_v1.ErrOffsetOutOfRange); ok {
+						continue
+					}
+					if !assert.NoError(t, err) || !assert.Equal(t, off, record.Offset) {
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	<-done
+	wg.Wait()
+}
@@ -0,0 +1,383 @@
+package log
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultTopic is the topic of the requests naming none.
+	DefaultTopic = "default"
+
+	maxTopicLen = 249
+)
+
+// LogManager owns the topics, each one kept in its own subdirectory of the data directory and opened on first use. It
+// also keeps the offsets committed by the consumer groups.
+type LogManager struct {
+	Dir string
+	// Config is the config of the topics without override.
+	Config Config
+	// Overrides holds the config of the topics not using the default one.
+	Overrides map[string]Config
+
+	mu      sync.Mutex
+	topics  map[string]*Topic
+	offsets *offsetStore
+	lock    *os.File
+	closed  bool
+
+	logger *zap.Logger
+}
+
+// NewLogManager opens the data directory and the default topic, which always exists. The data directory is locked
+// until the manager is closed. A log written directly in the data directory, before topics were introduced, is moved
+// into the default topic, and the log of a topic created before topics were partitioned into its first partition.
+func NewLogManager(dir string, c Config, overrides map[string]Config) (*LogManager, error) {
+	m := &LogManager{
+		Dir:       dir,
+		Config:    c,
+		Overrides: overrides,
+		topics:    make(map[string]*Topic),
+		logger:    zap.L().Named("log_manager"),
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if m.lock, err = lockDir(dir); err != nil {
+		return nil, err
+	}
+	if err = m.setup(); err != nil {
+		return nil, errors.Join(err, m.lock.Close())
+	}
+
+	if _, err := m.EnsureTopic(DefaultTopic); err != nil {
+		return nil, errors.Join(err, m.Close())
+	}
+
+	return m, nil
+}
+
+// setup brings the data directory up to date and opens the committed offsets. The caller must hold the lock of the data
+// directory.
+func (m *LogManager) setup() error {
+	n, err := moveLog(m.Dir, path.Join(m.Dir, DefaultTopic))
+	if err != nil {
+		return err
+	}
+	if n != 0 {
+		m.logger.Info("moved the log into the default topic", zap.Int("files", n))
+	}
+
+	files, err := os.ReadDir(m.Dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := file.Name()
+		switch {
+		case strings.HasPrefix(name, creatingPrefix):
+			// topics whose creation was interrupted are discarded
+			if err = os.RemoveAll(path.Join(m.Dir, name)); err != nil {
+				return err
+			}
+		case file.IsDir() && validateTopic(name) == nil:
+			if err = migrateTopicDir(path.Join(m.Dir, name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	m.offsets, err = newOffsetStore(path.Join(m.Dir, offsetsDir), m.Config)
+
+	return err
+}
+
+// Topic returns the topic, opening it on first use. The empty topic is the default one.
+func (m *LogManager) Topic(topic string) (*Topic, error) {
+	return m.get(topic, false)
+}
+
+// EnsureTopic returns the topic, creating it with the configured number of partitions when it does not exist.
+func (m *LogManager) EnsureTopic(topic string) (*Topic, error) {
+	return m.get(topic, true)
+}
+
+func (m *LogManager) get(topic string, create bool) (*Topic, error) {
+	if topic == "" {
+		topic = DefaultTopic
+	}
+	if err := validateTopic(topic); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.topics[topic]; ok {
+		return t, nil
+	}
+
+	exists, err := m.exists(topic)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if !create {
+			return nil, log_v1.ErrTopicNotFound{Topic: topic}
+		}
+		if err = createTopicDir(m.Dir, topic, m.config(topic).Topic.Partitions); err != nil {
+			return nil, err
+		}
+	}
+
+	return m.open(topic)
+}
+
+// CreateTopic creates the topic with the given number of partitions, zero meaning the configured one. It fails with
+// ErrTopicExists when the topic already exists.
+func (m *LogManager) CreateTopic(topic string, partitions int) (*Topic, error) {
+	if err := validateTopic(topic); err != nil {
+		return nil, err
+	}
+	if partitions < 0 {
+		return nil, log_v1.ErrInvalidTopic{Topic: topic, Reason: "negative number of partitions"}
+	}
+	if partitions == 0 {
+		partitions = m.config(topic).Topic.Partitions
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	exists, err := m.exists(topic)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, log_v1.ErrTopicExists{Topic: topic}
+	}
+
+	if err = createTopicDir(m.Dir, topic, partitions); err != nil {
+		return nil, err
+	}
+
+	return m.open(topic)
+}
+
+// DeleteTopic removes the topic along with all its records, the offloaded ones included. The default topic cannot be
+// deleted.
+func (m *LogManager) DeleteTopic(topic string) error {
+	if err := validateTopic(topic); err != nil {
+		return err
+	}
+	if topic == DefaultTopic {
+		return log_v1.ErrInvalidTopic{Topic: topic, Reason: "the default topic cannot be deleted"}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.topics[topic]
+	if !ok {
+		exists, err := m.exists(topic)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return log_v1.ErrTopicNotFound{Topic: topic}
+		}
+
+		// the partitions are opened to find their offloaded segments
+		if t, err = m.open(topic); err != nil {
+			return err
+		}
+	}
+
+	delete(m.topics, topic)
+	if err := t.remove(); err != nil {
+		return err
+	}
+
+	return m.offsets.removeTopic(topic)
+}
+
+// Topics describes the topics in lexical order.
+func (m *LogManager) Topics() ([]TopicInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	files, err := os.ReadDir(m.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var topics []TopicInfo
+	for _, file := range files {
+		if !file.IsDir() || validateTopic(file.Name()) != nil {
+			continue
+		}
+
+		md, err := loadTopicMetadata(path.Join(m.Dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		topics = append(topics, TopicInfo{Name: file.Name(), Partitions: md.Partitions})
+	}
+
+	return topics, nil
+}
+
+// CommitOffset stores the offset committed by the consumer group for the partition of the topic, which is the offset
+// of the next record the group consumes. A later commit replaces it, whether the offset is higher or not.
+func (m *LogManager) CommitOffset(group, topic string, partition int, off uint64) error {
+	key, err := m.partitionKey(group, topic, partition)
+	if err != nil {
+		return err
+	}
+
+	return m.offsets.commit(key, off)
+}
+
+// FetchOffset returns the offset committed by the consumer group for the partition of the topic, ErrOffsetNotCommitted
+// when there is none.
+func (m *LogManager) FetchOffset(group, topic string, partition int) (uint64, error) {
+	key, err := m.partitionKey(group, topic, partition)
+	if err != nil {
+		return 0, err
+	}
+
+	off, ok := m.offsets.fetch(key)
+	if !ok {
+		return 0, log_v1.ErrOffsetNotCommitted{Group: group, Topic: key.topic, Partition: uint32(partition)}
+	}
+
+	return off, nil
+}
+
+// partitionKey checks that the group is valid and the partition exists.
+func (m *LogManager) partitionKey(group, topic string, partition int) (partitionKey, error) {
+	if err := ValidateGroup(group); err != nil {
+		return partitionKey{}, err
+	}
+
+	t, err := m.Topic(topic)
+	if err != nil {
+		return partitionKey{}, err
+	}
+	if _, err = t.Partition(partition); err != nil {
+		return partitionKey{}, err
+	}
+
+	return partitionKey{group: group, topic: t.Name, partition: partition}, nil
+}
+
+// Close closes the partitions of all the topics.
+func (m *LogManager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+
+	var errs []error
+	for _, t := range m.topics {
+		errs = append(errs, t.close())
+	}
+	clear(m.topics)
+	errs = append(errs, m.offsets.close(), m.lock.Close())
+
+	return errors.Join(errs...)
+}
+
+// config returns the config of the topic.
+func (m *LogManager) config(topic string) Config {
+	c := m.Config
+	if override, ok := m.Overrides[topic]; ok {
+		c = override
+	}
+	if c.Topic.Partitions == 0 {
+		c.Topic.Partitions = 1
+	}
+
+	return c
+}
+
+// exists tells whether the topic was created. The caller must hold the lock.
+func (m *LogManager) exists(topic string) (bool, error) {
+	info, err := os.Stat(path.Join(m.Dir, topic))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	return info.IsDir(), nil
+}
+
+// open opens the partitions of the topic. The caller must hold the lock.
+func (m *LogManager) open(topic string) (*Topic, error) {
+	if m.closed {
+		return nil, errors.New("log manager closed")
+	}
+
+	t := &Topic{Name: topic, Dir: path.Join(m.Dir, topic)}
+	md, err := loadTopicMetadata(t.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for p := 0; p < md.Partitions; p++ {
+		c := m.config(topic)
+		// the partitions share the object store
+		if c.Tiering.Store != nil {
+			if prefix := objectPrefix(topic, p); prefix != "" {
+				c.Tiering.Store = prefixedObjectStore{store: c.Tiering.Store, prefix: prefix}
+			}
+		}
+
+		l, err := NewLog(path.Join(t.Dir, strconv.Itoa(p)), c)
+		if err != nil {
+			return nil, errors.Join(err, t.close())
+		}
+
+		t.partitions = append(t.partitions, l)
+	}
+	m.topics[topic] = t
+
+	return t, nil
+}
+
+// validateTopic checks that the topic name can be used as a directory name. Names starting with a dot are reserved for
+// the data directory entries of the logs.
+func validateTopic(topic string) error {
+	switch {
+	case topic == "":
+		return log_v1.ErrInvalidTopic{Topic: topic, Reason: "empty name"}
+	case len(topic) > maxTopicLen:
+		return log_v1.ErrInvalidTopic{Topic: topic, Reason: "name too long"}
+	case topic[0] == '.':
+		return log_v1.ErrInvalidTopic{Topic: topic, Reason: "name starts with a dot"}
+	}
+
+	for _, r := range topic {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '.' || r == '_' || r == '-') {
+			return log_v1.ErrInvalidTopic{Topic: topic, Reason: "name holds characters other than letters, digits, '.', '_' and '-'"}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,290 @@
+package log
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+)
+
+func TestLogManager(t *testing.T) {
+	dir := t.TempDir()
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 4096
+	override := c
+	override.Segment.MaxStoreBytes = 1024
+	m, err := NewLogManager(dir, c, map[string]Config{"orders": override})
+	require.NoError(t, err)
+
+	// the default topic always exists and is the one of the requests naming none
+	topics, err := m.Topics()
+	require.NoError(t, err)
+	require.Equal(t, []TopicInfo{{Name: DefaultTopic, Partitions: 1}}, topics)
+	def, err := m.Topic("")
+	require.NoError(t, err)
+	appendRecords(t, partition(t, def, 0), 2)
+
+	_, err = m.Topic("orders")
+	require.Equal(t, log_v1.ErrTopicNotFound{Topic: "orders"}, err)
+
+	orders, err := m.CreateTopic("orders", 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1024), partition(t, orders, 0).Config.Segment.MaxStoreBytes)
+	appendRecords(t, partition(t, orders, 0), 3)
+	_, err = m.CreateTopic("orders", 0)
+	require.Equal(t, log_v1.ErrTopicExists{Topic: "orders"}, err)
+
+	// producing creates the topic
+	users, err := m.EnsureTopic("users")
+	require.NoError(t, err)
+	require.Equal(t, uint64(4096), partition(t, users, 0).Config.Segment.MaxStoreBytes)
+	same, err := m.EnsureTopic("users")
+	require.NoError(t, err)
+	require.Same(t, users, same)
+
+	topics, err = m.Topics()
+	require.NoError(t, err)
+	require.Equal(t, []TopicInfo{{DefaultTopic, 1}, {"orders", 1}, {"users", 1}}, topics)
+
+	for _, topic := range []string{".remote", "a/b", "..", string(make([]byte, maxTopicLen+1))} {
+		_, err = m.EnsureTopic(topic)
+		require.IsType(t, log_v1.ErrInvalidTopic{}, err, topic)
+	}
+	require.IsType(t, log_v1.ErrInvalidTopic{}, m.DeleteTopic(DefaultTopic))
+
+	require.NoError(t, m.DeleteTopic("users"))
+	require.Equal(t, log_v1.ErrTopicNotFound{Topic: "users"}, m.DeleteTopic("users"))
+	require.NoDirExists(t, path.Join(dir, "users"))
+
+	// the topics are opened again on first use
+	require.NoError(t, m.Close())
+	m, err = NewLogManager(dir, c, nil)
+	require.NoError(t, err)
+	defer m.Close()
+
+	orders, err = m.Topic("orders")
+	require.NoError(t, err)
+	off, err := partition(t, orders, 0).HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+
+	// a topic deleted before being opened again is deleted too
+	require.NoError(t, m.DeleteTopic("orders"))
+	topics, err = m.Topics()
+	require.NoError(t, err)
+	require.Equal(t, []TopicInfo{{DefaultTopic, 1}}, topics)
+}
+
+func TestTopicPartitions(t *testing.T) {
+	dir := t.TempDir()
+
+	c := Config{}
+	c.Topic.Partitions = 3
+	m, err := NewLogManager(dir, c, nil)
+	require.NoError(t, err)
+
+	orders, err := m.CreateTopic("orders", 4)
+	require.NoError(t, err)
+	require.Equal(t, 4, orders.Partitions())
+	users, err := m.EnsureTopic("users")
+	require.NoError(t, err)
+	require.Equal(t, 3, users.Partitions())
+
+	_, err = orders.Partition(4)
+	require.Equal(t, log_v1.ErrPartitionNotFound{Topic: "orders", Partition: 4}, err)
+
+	// records with the same key go to the same partition, the others in turn
+	p := orders.PartitionFor([]byte("user-1"))
+	for i := 0; i < 3; i++ {
+		require.Equal(t, p, orders.PartitionFor([]byte("user-1")))
+	}
+	seen := make(map[int]bool)
+	for i := 0; i < 4; i++ {
+		seen[orders.PartitionFor(nil)] = true
+	}
+	require.Len(t, seen, 4)
+
+	appendRecords(t, partition(t, orders, 2), 2)
+
+	// the partition count is kept in the topic
+	require.NoError(t, m.Close())
+	m, err = NewLogManager(dir, Config{}, nil)
+	require.NoError(t, err)
+	defer m.Close()
+
+	topics, err := m.Topics()
+	require.NoError(t, err)
+	require.Equal(t, []TopicInfo{{DefaultTopic, 3}, {"orders", 4}, {"users", 3}}, topics)
+
+	orders, err = m.Topic("orders")
+	require.NoError(t, err)
+	off, err := partition(t, orders, 2).HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+}
+
+func TestLogManagerMigratesLog(t *testing.T) {
+	dir := t.TempDir()
+
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	appendRecords(t, log, 3)
+	require.NoError(t, log.Close())
+
+	// a topic from before topics were partitioned
+	log, err = NewLog(path.Join(dir, "orders"), Config{})
+	require.NoError(t, err)
+	appendRecords(t, log, 2)
+	require.NoError(t, log.Close())
+
+	m, err := NewLogManager(dir, Config{}, nil)
+	require.NoError(t, err)
+	defer m.Close()
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	require.Equal(t, []string{lockFile, offsetsDir, DefaultTopic, "orders"}, names)
+
+	// the topics are migrated on startup, listing them only reads
+	_, err = os.Stat(path.Join(dir, "orders", topicFile))
+	require.NoError(t, err)
+	topics, err := m.Topics()
+	require.NoError(t, err)
+	require.Equal(t, []TopicInfo{{Name: DefaultTopic, Partitions: 1}, {Name: "orders", Partitions: 1}}, topics)
+
+	// the data directory stays locked until the manager is closed
+	_, err = NewLogManager(dir, Config{}, nil)
+	require.ErrorIs(t, err, ErrDataDirLocked)
+
+	for topic, want := range map[string]uint64{DefaultTopic: 2, "orders": 1} {
+		tp, err := m.Topic(topic)
+		require.NoError(t, err)
+		require.Equal(t, 1, tp.Partitions())
+		off, err := partition(t, tp, 0).HighestOffset()
+		require.NoError(t, err)
+		require.Equal(t, want, off)
+	}
+}
+
+func TestLogManagerTiering(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := NewLocalObjectStore(path.Join(dir, "objects"))
+	require.NoError(t, err)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 2
+	c.Tiering.Store = store
+	m, err := NewLogManager(path.Join(dir, "data"), c, nil)
+	require.NoError(t, err)
+	defer m.Close()
+
+	_, err = m.CreateTopic("orders", 2)
+	require.NoError(t, err)
+	for _, topic := range []string{DefaultTopic, "orders"} {
+		tp, err := m.Topic(topic)
+		require.NoError(t, err)
+		for p := 0; p < tp.Partitions(); p++ {
+			l := partition(t, tp, p)
+			appendRecords(t, l, 3)
+			_, err = l.Offload()
+			require.NoError(t, err)
+		}
+	}
+
+	// the default topic keeps the names it had before topics, the first partition of the others the ones they had
+	// before partitions
+	names, err := store.List("")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"0.index", "0.store", "0.timeindex",
+		"orders/0.index", "orders/0.store", "orders/0.timeindex",
+		"orders/1/0.index", "orders/1/0.store", "orders/1/0.timeindex",
+	}, names)
+
+	require.NoError(t, m.DeleteTopic("orders"))
+	names, err = store.List("")
+	require.NoError(t, err)
+	require.Equal(t, []string{"0.index", "0.store", "0.timeindex"}, names)
+}
+
+func partition(t *testing.T, topic *Topic, p int) *Log {
+	t.Helper()
+
+	l, err := topic.Partition(p)
+	require.NoError(t, err)
+
+	return l
+}
+
+func TestLogManagerOffsets(t *testing.T) {
+	dir := t.TempDir()
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 2
+	m, err := NewLogManager(dir, c, nil)
+	require.NoError(t, err)
+
+	_, err = m.CreateTopic("orders", 2)
+	require.NoError(t, err)
+
+	_, err = m.FetchOffset("billing", "orders", 1)
+	require.Equal(t, log_v1.ErrOffsetNotCommitted{Group: "billing", Topic: "orders", Partition: 1}, err)
+
+	for off := uint64(1); off <= 5; off++ {
+		require.NoError(t, m.CommitOffset("billing", "orders", 1, off))
+	}
+	require.NoError(t, m.CommitOffset("billing", "", 0, 7))
+	require.NoError(t, m.CommitOffset("shipping", "orders", 1, 2))
+
+	require.IsType(t, log_v1.ErrInvalidGroup{}, m.CommitOffset("", "orders", 0, 1))
+	require.Equal(t, log_v1.ErrTopicNotFound{Topic: "users"}, m.CommitOffset("billing", "users", 0, 1))
+	require.Equal(t, log_v1.ErrPartitionNotFound{Topic: "orders", Partition: 2}, m.CommitOffset("billing", "orders", 2, 1))
+
+	// compaction keeps the latest commit of every partition
+	compacted, err := m.offsets.log.Compact(time.Now())
+	require.NoError(t, err)
+	require.NotEmpty(t, compacted)
+
+	// the offsets are loaded back from the log
+	require.NoError(t, m.Close())
+	m, err = NewLogManager(dir, c, nil)
+	require.NoError(t, err)
+	defer m.Close()
+
+	for _, tc := range []struct {
+		group string
+		topic string
+		want  uint64
+	}{
+		{"billing", "orders", 5},
+		{"billing", DefaultTopic, 7},
+		{"shipping", "orders", 2},
+	} {
+		p := 1
+		if tc.topic == DefaultTopic {
+			p = 0
+		}
+		off, err := m.FetchOffset(tc.group, tc.topic, p)
+		require.NoError(t, err)
+		require.Equal(t, tc.want, off)
+	}
+
+	// a topic created again does not get the offsets of the deleted one
+	require.NoError(t, m.DeleteTopic("orders"))
+	_, err = m.CreateTopic("orders", 2)
+	require.NoError(t, err)
+	_, err = m.FetchOffset("billing", "orders", 1)
+	require.IsType(t, log_v1.ErrOffsetNotCommitted{}, err)
+	off, err := m.FetchOffset("billing", DefaultTopic, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), off)
+}
@@ -0,0 +1,137 @@
+package log
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ObjectStore keeps named blobs, such as the files of the segments offloaded by tiered storage. Get returns an error
+// satisfying errors.Is(err, os.ErrNotExist) when the object does not exist.
+type ObjectStore interface {
+	Put(name string, r io.Reader) error
+	Get(name string) (io.ReadCloser, error)
+	// List returns the names of the objects starting with prefix in lexical order.
+	List(prefix string) ([]string, error)
+	// Delete removes the object, deleting an object which does not exist is not an error.
+	Delete(name string) error
+}
+
+var _ ObjectStore = (*LocalObjectStore)(nil)
+
+// LocalObjectStore keeps the objects as files of a directory, which may be on another disk or a network file system.
+type LocalObjectStore struct {
+	dir string
+}
+
+func NewLocalObjectStore(dir string) (*LocalObjectStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &LocalObjectStore{dir: dir}, nil
+}
+
+// Put writes the object to a temporary file renamed once complete, so an object is never seen partially written.
+func (s *LocalObjectStore) Put(name string, r io.Reader) error {
+	f, err := os.CreateTemp(s.dir, ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err = io.Copy(f, r); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	name = path.Join(s.dir, name)
+	if err = os.MkdirAll(path.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), name)
+}
+
+func (s *LocalObjectStore) Get(name string) (io.ReadCloser, error) {
+	return os.Open(path.Join(s.dir, name))
+}
+
+// List walks the directory, objects whose names hold slashes being kept in subdirectories.
+func (s *LocalObjectStore) List(prefix string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(s.dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// temporary files of the objects being put are hidden
+		if strings.HasPrefix(d.Name(), ".") && name != s.dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		if name, err = filepath.Rel(s.dir, name); err != nil {
+			return err
+		}
+		if name = filepath.ToSlash(name); strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (s *LocalObjectStore) Delete(name string) error {
+	if err := os.Remove(path.Join(s.dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// prefixedObjectStore keeps its objects under a prefix of a store shared with others, such as the logs of the topics.
+type prefixedObjectStore struct {
+	store  ObjectStore
+	prefix string
+}
+
+func (s prefixedObjectStore) Put(name string, r io.Reader) error {
+	return s.store.Put(s.prefix+name, r)
+}
+
+func (s prefixedObjectStore) Get(name string) (io.ReadCloser, error) {
+	return s.store.Get(s.prefix + name)
+}
+
+func (s prefixedObjectStore) List(prefix string) ([]string, error) {
+	names, err := s.store.List(s.prefix + prefix)
+	for i, name := range names {
+		names[i] = strings.TrimPrefix(name, s.prefix)
+	}
+
+	return names, err
+}
+
+func (s prefixedObjectStore) Delete(name string) error {
+	return s.store.Delete(s.prefix + name)
+}
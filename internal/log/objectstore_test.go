@@ -0,0 +1,56 @@
+package log
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalObjectStore(t *testing.T) {
+	dir, err := os.MkdirTemp("", "object-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := NewLocalObjectStore(dir)
+	require.NoError(t, err)
+
+	for _, name := range []string{"3.store", "3.index", "10.store"} {
+		require.NoError(t, s.Put(name, bytes.NewReader([]byte(name))))
+	}
+
+	r, err := s.Get("3.index")
+	require.NoError(t, err)
+	p, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "3.index", string(p))
+
+	names, err := s.List("")
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.store", "3.index", "3.store"}, names)
+
+	names, err = s.List("3.")
+	require.NoError(t, err)
+	require.Equal(t, []string{"3.index", "3.store"}, names)
+
+	require.NoError(t, s.Delete("3.index"))
+	require.NoError(t, s.Delete("3.index"))
+	_, err = s.Get("3.index")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// names holding slashes are kept in subdirectories, as the objects of a prefixed store
+	prefixed := prefixedObjectStore{store: s, prefix: "orders/"}
+	require.NoError(t, prefixed.Put("3.store", bytes.NewReader([]byte("orders"))))
+	names, err = s.List("")
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.store", "3.store", "orders/3.store"}, names)
+	names, err = prefixed.List("")
+	require.NoError(t, err)
+	require.Equal(t, []string{"3.store"}, names)
+	require.NoError(t, prefixed.Delete("3.store"))
+	_, err = s.Get("orders/3.store")
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
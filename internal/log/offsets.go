@@ -0,0 +1,221 @@
+package log
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+)
+
+const (
+	// offsetsDir holds the log of the committed offsets in the data directory of a LogManager. Its leading dot keeps it
+	// apart from the topics.
+	offsetsDir = ".offsets"
+
+	// offsetCommitKey starts the key of the records holding a committed offset.
+	offsetCommitKey byte = 1
+
+	maxGroupLen = 249
+)
+
+// partitionKey identifies a partition of a topic as seen by a consumer group.
+type partitionKey struct {
+	group     string
+	topic     string
+	partition int
+}
+
+// offsetStore keeps the offsets committed by the consumer groups in a compacted log, every commit being a record keyed
+// by the group and the partition, so compaction keeps the latest one. The offsets are served from a map rebuilt from
+// the log when it is opened.
+type offsetStore struct {
+	mu      sync.RWMutex
+	log     *Log
+	offsets map[partitionKey]uint64
+}
+
+// newOffsetStore opens the log of the committed offsets kept in dir and loads them.
+func newOffsetStore(dir string, c Config) (*offsetStore, error) {
+	// the commits are kept until superseded, never offloaded
+	c.Retention = Config{}.Retention
+	c.Tiering = Config{}.Tiering
+	c.Cache = Config{}.Cache
+	c.Compaction.Enabled = true
+
+	l, err := NewLog(dir, c)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &offsetStore{log: l, offsets: make(map[partitionKey]uint64)}
+	if err = s.load(); err != nil {
+		return nil, errors.Join(err, l.Close())
+	}
+
+	return s, nil
+}
+
+// load replays the records of the log, the later commits of a partition replacing the earlier ones.
+func (s *offsetStore) load() error {
+	off, err := s.log.LowestOffset()
+	if err != nil {
+		return err
+	}
+
+	for {
+		record, err := s.log.Read(off)
+		switch err.(type) {
+		case nil:
+		case log_v1.ErrOffsetOutOfRange:
+			return nil
+		default:
+			return err
+		}
+
+		if err = s.apply(record); err != nil {
+			return err
+		}
+		// compaction leaves gaps, so carry on after the record actually read
+		off = record.Offset + 1
+	}
+}
+
+// apply applies the record to the map, a record without value deleting the offset.
+func (s *offsetStore) apply(record *log_v1.Record) error {
+	key, err := decodePartitionKey(record.Key)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case len(record.Value) == 0:
+		delete(s.offsets, key)
+	case len(record.Value) == 8:
+		s.offsets[key] = enc.Uint64(record.Value)
+	default:
+		return fmt.Errorf("%w: committed offset of %d bytes", ErrIncompatibleFormat, len(record.Value))
+	}
+
+	return nil
+}
+
+// commit stores the offset committed by the group for the partition.
+func (s *offsetStore) commit(key partitionKey, off uint64) error {
+	value := make([]byte, 8)
+	enc.PutUint64(value, off)
+
+	return s.append([]partitionKey{key}, value)
+}
+
+// fetch returns the offset committed by the group for the partition.
+func (s *offsetStore) fetch(key partitionKey) (uint64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	off, ok := s.offsets[key]
+
+	return off, ok
+}
+
+// removeTopic deletes the offsets committed for the partitions of the topic, so a topic created again under the same
+// name is consumed from its beginning.
+func (s *offsetStore) removeTopic(topic string) error {
+	s.mu.RLock()
+	var keys []partitionKey
+	for key := range s.offsets {
+		if key.topic == topic {
+			keys = append(keys, key)
+		}
+	}
+	s.mu.RUnlock()
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return s.append(keys, nil)
+}
+
+// append appends a record holding the value for every key and applies them once appended.
+func (s *offsetStore) append(keys []partitionKey, value []byte) error {
+	now := time.Now().UnixNano()
+	records := make([]*log_v1.Record, len(keys))
+	for i, key := range keys {
+		// the timestamp tells compaction when a deletion may be forgotten
+		records[i] = &log_v1.Record{Key: key.encode(), Value: value, Timestamp: now}
+	}
+
+	// the lock is held while appending so the map follows the order of the log
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// records appended but not synced are applied all the same, as they are read back on load
+	offsets, err := s.log.AppendBatch(records)
+	if offsets == nil {
+		return err
+	}
+	for _, record := range records {
+		if err := s.apply(record); err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (s *offsetStore) close() error {
+	return s.log.Close()
+}
+
+// encode returns the key of the records holding the offsets of the partition: the commit key kind followed by the
+// length prefixed group and topic and the partition.
+func (k partitionKey) encode() []byte {
+	p := []byte{offsetCommitKey}
+	p = binary.AppendUvarint(p, uint64(len(k.group)))
+	p = append(p, k.group...)
+	p = binary.AppendUvarint(p, uint64(len(k.topic)))
+	p = append(p, k.topic...)
+
+	return binary.AppendUvarint(p, uint64(k.partition))
+}
+
+func decodePartitionKey(p []byte) (partitionKey, error) {
+	var k partitionKey
+	if len(p) == 0 || p[0] != offsetCommitKey {
+		return k, fmt.Errorf("%w: unknown committed offset key", ErrIncompatibleFormat)
+	}
+	p = p[1:]
+
+	var fields [2]string
+	for i := range fields {
+		n, w := binary.Uvarint(p)
+		if w <= 0 || n > uint64(len(p)-w) {
+			return k, fmt.Errorf("%w: truncated committed offset key", ErrIncompatibleFormat)
+		}
+		fields[i] = string(p[w : w+int(n)])
+		p = p[w+int(n):]
+	}
+
+	partition, w := binary.Uvarint(p)
+	if w <= 0 || w != len(p) {
+		return k, fmt.Errorf("%w: truncated committed offset key", ErrIncompatibleFormat)
+	}
+
+	return partitionKey{group: fields[0], topic: fields[1], partition: int(partition)}, nil
+}
+
+// ValidateGroup checks the name of a consumer group, the group coordinator and the committed offsets accepting the same
+// names.
+func ValidateGroup(group string) error {
+	switch {
+	case group == "":
+		return log_v1.ErrInvalidGroup{Group: group, Reason: "empty name"}
+	case len(group) > maxGroupLen:
+		return log_v1.ErrInvalidGroup{Group: group, Reason: "name too long"}
+	}
+
+	return nil
+}
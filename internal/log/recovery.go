@@ -0,0 +1,140 @@
+package log
+
+import (
+	"errors"
+)
+
+// recovery describes what was done to a segment while recovering it after an unclean shutdown.
+type recovery struct {
+	truncatedBytes uint64
+	droppedEntries uint64
+	rebuiltEntries uint64
+	corruptFrames  uint64
+}
+
+func (r recovery) changed() bool {
+	return r.truncatedBytes != 0 || r.droppedEntries != 0 || r.rebuiltEntries != 0 || r.corruptFrames != 0
+}
+
+// recover reconciles the index with the frames found in the store: entries pointing past the last complete frame are
+// dropped, missing entries are rebuilt and a torn frame at the tail of the store is truncated. Damaged frames in the
+// middle of the store are kept, so reading them reports the corruption instead of silently losing the records after.
+func (s *segment) recover() (recovery, error) {
+	var (
+		r   recovery
+		pos uint64
+		n   uint64
+		// i is the number of index entries checked so far
+		i uint64
+	)
+
+	had := s.index.entries()
+	for pos < s.store.size.Load() {
+		f, damaged, ok := s.checkFrame(pos)
+		if !ok {
+			break
+		}
+		if damaged {
+			r.corruptFrames++
+		}
+
+		off, at, err := s.index.Read(int64(i))
+		switch {
+		case err == nil && uint64(off) == n && at == pos:
+			i++
+			s.indexedPos = pos
+		case err == nil && uint64(off) > n:
+			// a sparse index skips the frame
+		default:
+			if err == nil {
+				// the entry does not match the frames, nor do the ones after it
+				s.index.truncate(i)
+			}
+			if s.needsIndex(pos) {
+				if err := s.index.Write(uint32(n), pos); err != nil {
+					return r, err
+				}
+				i++
+				s.indexedPos = pos
+				r.rebuiltEntries++
+			}
+		}
+
+		pos += f.width
+		n += s.frameRecords(f, damaged)
+	}
+
+	if pos < s.store.size.Load() {
+		r.truncatedBytes = s.store.size.Load() - pos
+		if err := s.store.truncate(pos); err != nil {
+			return r, err
+		}
+	}
+
+	if kept := i - r.rebuiltEntries; had > kept {
+		r.droppedEntries = had - kept
+	}
+
+	s.index.truncate(i)
+	s.nextOffset.Store(s.baseOffset + n)
+	s.publishedSize.Store(s.store.size.Load())
+
+	if err := s.timeIndex.truncate(uint32(n)); err != nil {
+		return r, err
+	}
+	s.loadMaxTimestamp()
+
+	return r, nil
+}
+
+// trimIndex drops the zeros preallocated at the end of the index of a closed segment and returns the number of entries
+// they made up. The index of a segment is only cut down to its entries once the segment is closed, so the zeros are left
+// in place when the process stops without closing it. The other entries are valid, as the frames of a closed segment
+// were all written before the next segment was created.
+func (s *segment) trimIndex() uint64 {
+	n := s.index.entries()
+	if n == 0 && s.store.size.Load() != 0 {
+		// the first frame of a segment is always indexed, and its entry is all zeros when it holds the base offset
+		n = 1
+	}
+
+	size := s.index.size.Load()
+	if size <= n*entWidth {
+		return 0
+	}
+	s.index.truncate(n)
+
+	return size/entWidth - n
+}
+
+// checkFrame tells whether the frame starting at pos is complete and returns it. A frame that fails its checksum is
+// reported as damaged but still complete unless it is the last one in the store, which is where torn writes happen.
+func (s *segment) checkFrame(pos uint64) (f frame, damaged bool, ok bool) {
+	f, err := s.store.readFrame(pos)
+	switch {
+	case errors.Is(err, errCorruptFrame):
+		return f, true, f.width != 0 && pos+f.width < s.store.size.Load()
+	case err != nil:
+		return f, false, false
+	case f.width == lenWidth && len(f.payload) == 0:
+		// zeros preallocated by the file system, nothing was written here
+		return f, false, false
+	}
+
+	return f, false, true
+}
+
+// frameRecords returns the number of offsets the frame takes in the active segment, whose offsets are contiguous. A
+// batch frame which cannot be decoded is taken as a single record, like a damaged frame.
+func (s *segment) frameRecords(f frame, damaged bool) uint64 {
+	if damaged || f.attrs&attrBatch == 0 {
+		return 1
+	}
+
+	records, err := decodeRecords(f, s.config.Encryption.Keyring)
+	if err != nil {
+		return 1
+	}
+
+	return uint64(len(records))
+}
@@ -0,0 +1,233 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+)
+
+func TestLogRecovery(t *testing.T) {
+	dir, err := os.MkdirTemp("", "recovery-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	c.Segment.MaxIndexBytes = entWidth * 16
+
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	record := &log_v1.Record{Value: []byte("hello world")}
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(record)
+		require.NoError(t, err)
+	}
+
+	// simulate a crash: the store is flushed but never closed, so the index file keeps its preallocated size, and
+	// the last write is torn in the middle of the frame
+	seg := log.activeSegment
+	require.NoError(t, seg.store.buf.Flush())
+	f, err := os.OpenFile(seg.store.Name(), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{byte(attrChecksum), 0, 0, 0, 0, 0, 0, 42, 1, 2, 3})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	// the lock of the data directory goes away with the crashed process
+	require.NoError(t, log.unlock())
+
+	recovered, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	off, err := recovered.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+	require.Equal(t, 3*entWidth, recovered.activeSegment.index.size.Load())
+	require.Equal(t, seg.store.size.Load(), recovered.activeSegment.store.size.Load())
+
+	off, err = recovered.Append(record)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+
+	for i := uint64(0); i < 4; i++ {
+		read, err := recovered.Read(i)
+		require.NoError(t, err)
+		require.Equal(t, i, read.Offset)
+		require.Equal(t, record.Value, read.Value)
+	}
+}
+
+func TestLogRecoveryBatch(t *testing.T) {
+	for scenario, codec := range map[string]Codec{
+		"uncompressed batch": CodecNone,
+		"compressed batch":   CodecGzip,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir := t.TempDir()
+
+			c := Config{}
+			c.Compression.Codec = codec
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+
+			batch := func() []*log_v1.Record {
+				return []*log_v1.Record{
+					{Value: []byte("hello world")},
+					{Value: []byte("hello world")},
+					{Value: []byte("hello world")},
+				}
+			}
+			_, err = log.AppendBatch(batch())
+			require.NoError(t, err)
+			_, err = log.AppendBatch(batch())
+			require.NoError(t, err)
+
+			// simulate a crash cutting the last batch in the middle, which is lost as a whole
+			seg := log.activeSegment
+			require.NoError(t, seg.store.buf.Flush())
+			f, err := seg.store.readFrame(0)
+			require.NoError(t, err)
+			last, err := seg.store.readFrame(f.width)
+			require.NoError(t, err)
+			require.NoError(t, os.Truncate(seg.store.Name(), int64(f.width+last.width/2)))
+			require.NoError(t, log.unlock())
+
+			recovered, err := NewLog(dir, c)
+			require.NoError(t, err)
+			defer recovered.Close()
+
+			off, err := recovered.HighestOffset()
+			require.NoError(t, err)
+			require.Equal(t, uint64(2), off)
+			require.Equal(t, entWidth, recovered.activeSegment.index.size.Load())
+
+			offsets, err := recovered.AppendBatch(batch())
+			require.NoError(t, err)
+			require.Equal(t, []uint64{3, 4, 5}, offsets)
+			for i := uint64(0); i < 6; i++ {
+				read, err := recovered.Read(i)
+				require.NoError(t, err)
+				require.Equal(t, i, read.Offset)
+			}
+		})
+	}
+}
+
+func TestSegmentRecoverRebuildsIndex(t *testing.T) {
+	dir, err := os.MkdirTemp("", "recovery-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	c.Segment.MaxIndexBytes = 1024
+
+	s, err := newSegment(dir, 0, c)
+	require.NoError(t, err)
+
+	record := &log_v1.Record{Value: []byte("hello world")}
+	for i := 0; i < 3; i++ {
+		_, err := s.Append(record)
+		require.NoError(t, err)
+	}
+
+	// lose the last index entry, as if the mmap was not written back before the crash
+	s.index.truncate(2)
+
+	r, err := s.recover()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), r.rebuiltEntries)
+	require.Equal(t, uint64(3), s.nextOffset.Load())
+
+	read, err := s.Read(2)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), read.Offset)
+}
+
+func TestSegmentRecoverSparseIndex(t *testing.T) {
+	dir, err := os.MkdirTemp("", "recovery-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	c.Segment.MaxIndexBytes = 1024
+	c.Segment.IndexIntervalBytes = 64
+
+	s, err := newSegment(dir, 0, c)
+	require.NoError(t, err)
+
+	record := &log_v1.Record{Value: []byte("hello world")}
+	for i := 0; i < 10; i++ {
+		_, err := s.Append(record)
+		require.NoError(t, err)
+	}
+	entries := s.index.size.Load() / entWidth
+	require.Less(t, entries, uint64(10))
+
+	// the records skipped by a sparse index are not missing entries
+	r, err := s.recover()
+	require.NoError(t, err)
+	require.False(t, r.changed())
+	require.Equal(t, entries, s.index.size.Load()/entWidth)
+	require.Equal(t, uint64(10), s.nextOffset.Load())
+
+	// lose the last index entry, it is rebuilt where the interval says
+	s.index.truncate(entries - 1)
+	r, err = s.recover()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), r.rebuiltEntries)
+	require.Equal(t, entries, s.index.size.Load()/entWidth)
+
+	for off := uint64(0); off < 10; off++ {
+		read, err := s.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, read.Offset)
+	}
+}
+
+func TestLogRecoveryClosedSegments(t *testing.T) {
+	dir, err := os.MkdirTemp("", "recovery-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+	c.Segment.MaxIndexBytes = entWidth * 16
+
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	record := &log_v1.Record{Value: []byte("hello world")}
+	for i := 0; i < 8; i++ {
+		_, err := log.Append(record)
+		require.NoError(t, err)
+	}
+	require.Greater(t, len(log.segments()), 2)
+
+	// simulate a crash: no segment is closed, so all the index files keep their preallocated size
+	require.NoError(t, log.activeSegment.store.buf.Flush())
+	require.NoError(t, log.unlock())
+
+	recovered, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer recovered.Close()
+
+	segments := recovered.segments()
+	require.Len(t, segments, len(log.segments()))
+	for _, seg := range segments {
+		require.Equal(t, (seg.nextOffset.Load()-seg.baseOffset)*entWidth, seg.index.size.Load())
+	}
+
+	for i := uint64(0); i < 8; i++ {
+		read, err := recovered.Read(i)
+		require.NoError(t, err)
+		require.Equal(t, i, read.Offset)
+	}
+
+	off, err := recovered.Append(record)
+	require.NoError(t, err)
+	require.Equal(t, uint64(8), off)
+}
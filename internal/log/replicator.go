@@ -1,17 +1,30 @@
 package log
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"sync"
+	"time"
 
 	"github.com/vlamug/pdlog/api/v1"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// replicateMaxBytes bounds the records fetched from a server at once.
+	replicateMaxBytes = 1 << 20
+	// replicatePollInterval is how long to wait before asking a server for new records once caught up with it.
+	replicatePollInterval = 100 * time.Millisecond
 )
 
 type Replicator struct {
 	DialOptions []grpc.DialOption
 	LocalServer api.LogClient
+	// Keyring decrypts the records fetched from servers encrypting them.
+	Keyring *Keyring
 
 	mu      sync.Mutex
 	servers map[string]chan struct{}
@@ -51,38 +64,63 @@ func (r *Replicator) replicate(addr string, leave chan struct{}) {
 
 	client := api.NewLogClient(cc)
 	ctx := context.Background()
-	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0})
-	if err != nil {
-		r.logError(err, "failed to consume", addr)
-		return
-	}
-
-	records := make(chan *api.Record)
-	go func() {
-		for {
-			recv, err := stream.Recv()
-			if err != nil {
-				r.logError(err, "failed to receive", addr)
-				return
-			}
-
-			records <- recv.Record
-		}
-	}()
+	outOfRange := status.Code(api.ErrOffsetOutOfRange{}.GRPCStatus().Err())
 
+	// the records are fetched in chunks as stored by the server and decoded here
+	var off uint64
 	for {
 		select {
 		case <-r.close:
 			return
 		case <-leave:
 			return
-		case record := <-records:
-			_, err := r.LocalServer.Produce(ctx, &api.ProduceRequest{Record: record})
+		default:
+		}
+
+		res, err := client.ReadRange(ctx, &api.ReadRangeRequest{Offset: off, MaxBytes: replicateMaxBytes})
+		switch {
+		case status.Code(err) == outOfRange:
+			// the records were removed from the server, carry on from its oldest one
+			if lowest, ok := api.LowestOffset(err); ok && lowest > off {
+				off = lowest
+				continue
+			}
+			// the server has no new records yet
+			select {
+			case <-r.close:
+				return
+			case <-leave:
+				return
+			case <-time.After(replicatePollInterval):
+			}
+			continue
+		case err != nil:
+			r.logError(err, "failed to read range", addr)
+			return
+		}
+
+		dec := NewDecoder(bytes.NewReader(res.Frames))
+		dec.Keyring = r.Keyring
+		for {
+			record, err := dec.Decode()
+			if err == io.EOF {
+				break
+			}
 			if err != nil {
+				r.logError(err, "failed to decode", addr)
+				return
+			}
+			// a batch frame is read whole, along with its records before the requested offset
+			if record.Offset < res.FirstOffset {
+				continue
+			}
+
+			if _, err = r.LocalServer.Produce(ctx, &api.ProduceRequest{Record: record}); err != nil {
 				r.logError(err, "failed to produce", addr)
 				return
 			}
 		}
+		off = res.NextOffset
 	}
 }
 
@@ -92,7 +130,7 @@ func (r *Replicator) Leave(name string) error {
 
 	r.init()
 
-	if _, ok := r.servers[name]; ok {
+	if _, ok := r.servers[name]; !ok {
 		return nil
 	}
 
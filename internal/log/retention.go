@@ -0,0 +1,138 @@
+package log
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRetentionCheckInterval = time.Minute
+)
+
+const (
+	ReasonMaxAge   = "max_age"
+	ReasonMaxBytes = "max_bytes"
+)
+
+// RemovedSegment describes a segment deleted by the retention policy.
+type RemovedSegment struct {
+	// Reason is the limit the segment violated, either ReasonMaxAge or ReasonMaxBytes.
+	Reason     string
+	BaseOffset uint64
+	NextOffset uint64
+	// Bytes is the disk space the segment occupied.
+	Bytes uint64
+	// LastModified is when the newest record of the segment was written.
+	LastModified time.Time
+}
+
+// ApplyRetention removes the oldest closed segments violating the retention policy and returns what was removed.
+// Segments older than the max age go first, then the oldest ones until the log fits in the max bytes. The active
+// segment is never removed, so the log always keeps its latest records. With tiered storage, a segment is only removed
+// once offloaded, its records are then read from the object store.
+func (l *Log) ApplyRetention(now time.Time) ([]RemovedSegment, error) {
+	l.maintenance.Lock()
+	defer l.maintenance.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var removed []RemovedSegment
+	if maxAge := l.Config.Retention.MaxAge; maxAge != 0 {
+		for len(l.segments()) > 1 && l.reclaimable(l.segments()[0]) {
+			modTime, err := l.segments()[0].lastModified()
+			if err != nil {
+				return removed, err
+			}
+
+			if now.Sub(modTime) <= maxAge {
+				break
+			}
+
+			info, err := l.removeOldest(ReasonMaxAge)
+			if err != nil {
+				return removed, err
+			}
+			removed = append(removed, info)
+		}
+	}
+
+	if maxBytes := l.Config.Retention.MaxBytes; maxBytes != 0 {
+		var size uint64
+		for _, seg := range l.segments() {
+			size += seg.size()
+		}
+
+		for len(l.segments()) > 1 && size > maxBytes && l.reclaimable(l.segments()[0]) {
+			info, err := l.removeOldest(ReasonMaxBytes)
+			if err != nil {
+				return removed, err
+			}
+			size -= info.Bytes
+			removed = append(removed, info)
+		}
+	}
+
+	return removed, nil
+}
+
+// reclaimable tells whether the retention policy may remove the segment, which it may not before tiered storage
+// offloaded it.
+func (l *Log) reclaimable(seg *segment) bool {
+	return l.tier == nil || l.tier.uploaded(seg.baseOffset)
+}
+
+// removeOldest removes the first segment of the log. The caller must hold the lock.
+func (l *Log) removeOldest(reason string) (RemovedSegment, error) {
+	seg := l.segments()[0]
+	modTime, err := seg.lastModified()
+	if err != nil {
+		return RemovedSegment{}, err
+	}
+
+	info := RemovedSegment{
+		Reason:       reason,
+		BaseOffset:   seg.baseOffset,
+		NextOffset:   seg.nextOffset.Load(),
+		Bytes:        seg.size(),
+		LastModified: modTime,
+	}
+	l.publish(l.segments()[1:])
+	if err := seg.Remove(); err != nil {
+		return RemovedSegment{}, err
+	}
+
+	return info, nil
+}
+
+// retentionLoop applies the retention policy periodically until closing is closed.
+func (l *Log) retentionLoop(closing <-chan struct{}) {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.Config.Retention.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closing:
+			return
+		case now := <-ticker.C:
+			removed, err := l.ApplyRetention(now)
+			for _, seg := range removed {
+				l.logger.Info(
+					"removed segment",
+					zap.String("reason", seg.Reason),
+					zap.Uint64("base_offset", seg.BaseOffset),
+					zap.Uint64("next_offset", seg.NextOffset),
+					zap.Uint64("bytes", seg.Bytes),
+					zap.Time("last_modified", seg.LastModified),
+				)
+			}
+
+			if err != nil {
+				l.logger.Error("failed to apply retention", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package log
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+)
+
+func TestApplyRetentionMaxAge(t *testing.T) {
+	// every segment holds two records
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 2
+	c.Retention.MaxAge = time.Hour
+	log := newTestLog(t, c)
+	defer log.Remove()
+
+	// the first segment is older than the limit, the others are not
+	appendRecordsAt(t, log, 2, time.Now().Add(-2*time.Hour))
+	appendRecords(t, log, 2)
+	require.Len(t, log.segments(), 3)
+
+	removed, err := log.ApplyRetention(time.Now())
+	require.NoError(t, err)
+	require.Len(t, removed, 1)
+	require.Equal(t, uint64(0), removed[0].BaseOffset)
+	require.Equal(t, uint64(2), removed[0].NextOffset)
+	require.NotZero(t, removed[0].Bytes)
+
+	off, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+
+	_, err = log.Read(1)
+	require.Equal(t, log_v1.ErrOffsetOutOfRange{Offset: 1, Lowest: 2}, err)
+
+	// the active segment is kept however old it is
+	removed, err = log.ApplyRetention(time.Now().Add(24 * time.Hour))
+	require.NoError(t, err)
+	require.Len(t, removed, 1)
+	require.Len(t, log.segments(), 1)
+	require.Equal(t, log.activeSegment, log.segments()[0])
+}
+
+func TestApplyRetentionMaxBytes(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 2
+	log := newTestLog(t, c)
+	defer log.Remove()
+
+	appendRecords(t, log, 6)
+	require.Len(t, log.segments(), 4)
+
+	// keep room for the active segment and the newest closed one
+	log.Config.Retention.MaxBytes = log.segments()[2].size() + log.segments()[3].size()
+
+	removed, err := log.ApplyRetention(time.Now())
+	require.NoError(t, err)
+	require.Len(t, removed, 2)
+	for i, seg := range removed {
+		require.Equal(t, ReasonMaxBytes, seg.Reason)
+		require.Equal(t, uint64(i*2), seg.BaseOffset)
+	}
+
+	off, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), off)
+
+	_, err = log.Read(3)
+	require.Equal(t, log_v1.ErrOffsetOutOfRange{Offset: 3, Lowest: 4}, err)
+
+	read, err := log.Read(4)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), read.Offset)
+
+	// the active segment is kept even if it alone exceeds the limit
+	log.Config.Retention.MaxBytes = 1
+	removed, err = log.ApplyRetention(time.Now())
+	require.NoError(t, err)
+	require.Len(t, removed, 1)
+	require.Len(t, log.segments(), 1)
+}
+
+func TestRetentionLoop(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 2
+	c.Retention.MaxAge = time.Hour
+	c.Retention.CheckInterval = 10 * time.Millisecond
+	log := newTestLog(t, c)
+	defer log.Remove()
+
+	appendRecordsAt(t, log, 4, time.Now().Add(-2*time.Hour))
+
+	require.Eventually(t, func() bool {
+		off, err := log.LowestOffset()
+		return err == nil && off == 4
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestApplyRetentionLegacySegments(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 2
+	c.Retention.MaxAge = time.Hour
+	log := newTestLog(t, c)
+	defer log.Remove()
+
+	appendRecords(t, log, 2)
+
+	// records written before timestamps were introduced fall back to the modification time of the store
+	seg := log.segments()[0]
+	seg.maxTimestamp = 0
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, seg.store.buf.Flush())
+	require.NoError(t, os.Chtimes(seg.store.Name(), old, old))
+
+	removed, err := log.ApplyRetention(time.Now())
+	require.NoError(t, err)
+	require.Len(t, removed, 1)
+	require.Equal(t, old.Unix(), removed[0].LastModified.Unix())
+}
+
+func appendRecordsAt(t *testing.T, log *Log, n int, at time.Time) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		_, err := log.Append(&log_v1.Record{Value: []byte("hello world"), Timestamp: at.UnixNano()})
+		require.NoError(t, err)
+	}
+}
@@ -0,0 +1,46 @@
+package log
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// defaultRollCheckInterval is the longest the active segment may outlive the max segment age when no record is
+// appended.
+const defaultRollCheckInterval = time.Minute
+
+// rollIfOld rolls the active segment once it is older than the max segment age. A segment without records is kept, as
+// rolling it would reclaim nothing. The caller must hold the lock.
+func (l *Log) rollIfOld(now time.Time) error {
+	s := l.activeSegment
+	if l.Config.Segment.MaxAge == 0 || s.nextOffset.Load() == s.baseOffset || now.Sub(s.created) < l.Config.Segment.MaxAge {
+		return nil
+	}
+
+	return l.newSegment(s.nextOffset.Load())
+}
+
+// rollLoop periodically rolls the active segment once too old until closing is closed, so a segment no record is
+// appended to anymore does not stay active forever.
+func (l *Log) rollLoop(closing <-chan struct{}) {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(min(l.Config.Segment.MaxAge, defaultRollCheckInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closing:
+			return
+		case now := <-ticker.C:
+			l.mu.Lock()
+			err := l.rollIfOld(now)
+			l.mu.Unlock()
+
+			if err != nil {
+				l.logger.Error("failed to roll active segment", zap.Error(err))
+			}
+		}
+	}
+}
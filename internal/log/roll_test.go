@@ -0,0 +1,62 @@
+package log
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollIfOld(t *testing.T) {
+	dir, err := os.MkdirTemp("", "roll-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxAge = time.Hour
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	// an empty segment is not rolled however old it is
+	require.NoError(t, log.rollIfOld(time.Now().Add(2*time.Hour)))
+	require.Len(t, log.segments(), 1)
+
+	appendRecords(t, log, 2)
+	created := log.activeSegment.created
+
+	// the creation time survives restarts
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	require.True(t, created.Equal(log.activeSegment.created))
+
+	require.NoError(t, log.rollIfOld(created.Add(time.Hour-time.Second)))
+	require.Len(t, log.segments(), 1)
+
+	require.NoError(t, log.rollIfOld(created.Add(time.Hour)))
+	require.Len(t, log.segments(), 2)
+	require.Equal(t, uint64(2), log.activeSegment.baseOffset)
+	require.True(t, log.activeSegment.created.After(created))
+}
+
+func TestRollOldSegment(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxAge = 20 * time.Millisecond
+	log := newTestLog(t, c)
+	defer log.Remove()
+
+	// the append rolls the segment before writing to it
+	appendRecords(t, log, 1)
+	time.Sleep(30 * time.Millisecond)
+	appendRecords(t, log, 1)
+	require.GreaterOrEqual(t, len(log.segments()), 2)
+
+	// without appends, the background loop rolls it
+	require.Eventually(t, func() bool {
+		log.mu.RLock()
+		defer log.mu.RUnlock()
+		return log.activeSegment.baseOffset == 2
+	}, time.Second, 10*time.Millisecond)
+}
@@ -1,19 +1,53 @@
 package log
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	log_v1 "github.com/vlamug/pdlog/api/v1"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 )
 
+// errSegmentClosed is returned when reading a segment which was closed, e.g. because it was removed or replaced by a
+// compacted one after the reader picked it.
+var errSegmentClosed = errors.New("segment closed")
+
 type segment struct {
-	store                  *store
-	index                  *index
-	baseOffset, nextOffset uint64
-	config                 Config
+	// mu guards the lifetime of the segment: readers hold it shared so the segment is not closed under them, appends do
+	// not take it
+	mu     sync.RWMutex
+	closed bool
+
+	store      *store
+	index      *index
+	timeIndex  *timeIndex
+	baseOffset uint64
+	// nextOffset is moved past the appended records once they can be read, readers load it without locking
+	nextOffset atomic.Uint64
+	// publishedSize is the size of the store holding the records which can be read, frames past it may be rolled back
+	publishedSize atomic.Uint64
+	config        Config
+
+	// maxTimestamp is the highest timestamp of the segment records and maxTimestampOffset the offset of its record
+	maxTimestamp       int64
+	maxTimestampOffset uint64
+	// timeIndexedPos is the store position of the record last added to the time index
+	timeIndexedPos uint64
+	// indexedPos is the store position of the record last added to the index
+	indexedPos uint64
+
+	// created is when the segment was first created, it is kept in the created file so the age of the segment survives
+	// restarts
+	created     time.Time
+	createdFile string
 }
 
 func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
@@ -47,65 +81,526 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 		return nil, err
 	}
 
-	if off, _, err := s.index.Read(-1); err != nil {
-		s.nextOffset = baseOffset
-	} else {
-		s.nextOffset = baseOffset + uint64(off) + 1
+	timeIndexFile, err := os.OpenFile(
+		path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".timeindex")),
+		os.O_RDWR|os.O_CREATE|os.O_APPEND,
+		0644,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if s.timeIndex, err = newTimeIndex(timeIndexFile); err != nil {
+		return nil, err
+	}
+
+	s.createdFile = path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".created"))
+	if s.created, err = loadCreated(s.createdFile); err != nil {
+		return nil, err
+	}
+
+	s.nextOffset.Store(baseOffset)
+	if off, pos, err := s.index.Read(-1); err == nil {
+		s.nextOffset.Store(baseOffset + uint64(off) + 1)
+		s.indexedPos = pos
+		// a sparse index does not cover the last records, the store does
+		_ = s.scan(s.nextOffset.Load()-1, pos, func(off, _ uint64, _ frame, _ *log_v1.Record) (bool, error) {
+			s.nextOffset.Store(off + 1)
+			return true, nil
+		})
 	}
+	s.loadMaxTimestamp()
+	s.publishedSize.Store(s.store.size.Load())
 
 	return s, nil
 }
 
+// loadCreated reads the creation time of a segment from the file, or writes the current time into it for a new
+// segment. Segments from before creation times were kept are considered created when first opened.
+func loadCreated(name string) (time.Time, error) {
+	p, err := os.ReadFile(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, err
+	}
+	// a file torn by a crash is rewritten as well
+	if len(p) == 8 {
+		return time.Unix(0, int64(enc.Uint64(p))), nil
+	}
+
+	now := time.Now()
+	p = make([]byte, 8)
+	enc.PutUint64(p, uint64(now.UnixNano()))
+
+	return now, os.WriteFile(name, p, 0644)
+}
+
+// loadMaxTimestamp restores the highest timestamp of the segment from the time index and the records appended after
+// its last entry, which are not indexed when the segment was not closed properly.
+func (s *segment) loadMaxTimestamp() {
+	s.maxTimestamp, s.maxTimestampOffset = 0, 0
+	off := s.baseOffset
+	if last, ok := s.timeIndex.last(); ok {
+		s.maxTimestamp = last.timestamp
+		s.maxTimestampOffset = s.baseOffset + uint64(last.off)
+		off = s.maxTimestampOffset + 1
+	}
+
+	if out, pos, err := s.index.lookup(uint32(off - s.baseOffset)); err == nil {
+		_ = s.scan(s.baseOffset+uint64(out), pos, func(cur, _ uint64, _ frame, record *log_v1.Record) (bool, error) {
+			if cur >= off && record != nil && record.Timestamp > s.maxTimestamp {
+				s.maxTimestamp = record.Timestamp
+				s.maxTimestampOffset = cur
+			}
+			return true, nil
+		})
+	}
+
+	s.timeIndexedPos = s.store.size.Load()
+}
+
 func (s *segment) Append(record *log_v1.Record) (offset uint64, err error) {
-	cur := s.nextOffset
-	record.Offset = cur
-	p, err := proto.Marshal(record)
+	return s.AppendBatch([]*log_v1.Record{record})
+}
+
+// AppendBatch appends the records contiguously under consecutive offsets and returns the offset of the first one.
+// Either all the records are appended or none: on failure the store and the index are rolled back. The records are
+// flushed to the store file before being published to readers. They are stored in a single frame, so recovery keeps
+// either all of them or none and the codec works on the whole batch.
+func (s *segment) AppendBatch(records []*log_v1.Record) (offset uint64, err error) {
+	cur := s.nextOffset.Load()
+	storeSize, indexSize := s.store.size.Load(), s.index.size.Load()
+	maxTimestamp, maxTimestampOffset, timeIndexedPos := s.maxTimestamp, s.maxTimestampOffset, s.timeIndexedPos
+	indexedPos := s.indexedPos
+
+	err = s.write(records, cur)
+	if err == nil {
+		err = s.store.flush()
+	}
+
 	if err != nil {
+		if rerr := s.store.truncate(storeSize); rerr != nil {
+			return 0, rerr
+		}
+		s.index.truncate(indexSize / entWidth)
+		if rerr := s.timeIndex.truncate(uint32(cur - s.baseOffset)); rerr != nil {
+			return 0, rerr
+		}
+		s.maxTimestamp, s.maxTimestampOffset, s.timeIndexedPos = maxTimestamp, maxTimestampOffset, timeIndexedPos
+		s.indexedPos = indexedPos
+
 		return 0, err
 	}
 
-	_, pos, err := s.store.Append(p)
+	s.nextOffset.Add(uint64(len(records)))
+	s.publishedSize.Store(s.store.size.Load())
+	return cur, nil
+}
+
+// fits tells whether the records can be appended to the segment without exceeding its limits.
+func (s *segment) fits(records []*log_v1.Record) bool {
+	return s.fitting(records) == len(records)
+}
+
+// fitting returns how many of the first records can be appended to the segment without exceeding its limits.
+func (s *segment) fitting(records []*log_v1.Record) int {
+	return fitting(records, s.nextOffset.Load(), s.store.size.Load(), s.index.size.Load(), s.indexedPos, s.config)
+}
+
+// fitting returns how many of the first records can be appended under consecutive offsets starting at off to a segment
+// whose store and index take size and indexSize bytes, indexedPos being the position of its last indexed frame. The
+// width of the frames is an upper bound, as compression only ever makes them smaller, and a batch frame holding fewer
+// records than estimated is not larger either.
+func fitting(records []*log_v1.Record, off, size, indexSize, indexedPos uint64, c Config) int {
+	now := uint64(time.Now().UnixNano())
+	batch := batched(len(records))
+	for i, record := range records {
+		if !batch || i == 0 {
+			// the index entries are the ones needsIndex asks for
+			if interval := c.Segment.IndexIntervalBytes; interval == 0 || indexSize == 0 || size-indexedPos >= interval {
+				indexSize += entWidth
+				indexedPos = size
+			}
+
+			size += lenWidth + crcWidth
+			if c.Encryption.Keyring != nil {
+				size += encryptionOverhead
+			}
+			if batch {
+				size += uint64(protowire.SizeVarint(uint64(len(records))))
+			}
+		}
+
+		// the offset and the timestamp are set on append
+		n := uint64(proto.Size(record)) - varintFieldSize(record.Offset) + varintFieldSize(off+uint64(i))
+		if record.Timestamp == 0 {
+			n += varintFieldSize(now)
+		}
+		if batch {
+			n += uint64(protowire.SizeVarint(n))
+		}
+		size += n
+
+		if size > c.Segment.MaxStoreBytes || indexSize > c.Segment.MaxIndexBytes {
+			return i
+		}
+	}
+
+	return len(records)
+}
+
+// varintFieldSize returns the size of a varint field of the record holding v, zero values are not encoded.
+func varintFieldSize(v uint64) uint64 {
+	if v == 0 {
+		return 0
+	}
+
+	return uint64(protowire.SizeTag(1) + protowire.SizeVarint(v))
+}
+
+// write stores the records under consecutive offsets starting at off, they become visible to readers once nextOffset
+// is moved past them. Records without a timestamp are stamped with the append time.
+func (s *segment) write(records []*log_v1.Record, off uint64) error {
+	now := time.Now().UnixNano()
+	for i, record := range records {
+		record.Offset = off + uint64(i)
+		if record.Timestamp == 0 {
+			record.Timestamp = now
+		}
+	}
+
+	return s.writeRecords(records)
+}
+
+// writeRecords stores the records under their offsets, in a single frame when they are batched.
+func (s *segment) writeRecords(records []*log_v1.Record) error {
+	if batched(len(records)) {
+		p, attrs, err := encodeBatch(records, s.config)
+		if err != nil {
+			return err
+		}
+
+		return s.writeFrame(p, attrs, records)
+	}
+
+	for _, record := range records {
+		p, attrs, err := encodeRecord(record, s.config)
+		if err != nil {
+			return err
+		}
+
+		if err = s.writeFrame(p, attrs, []*log_v1.Record{record}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeFrame stores the encoded records and indexes them, the frame being indexed under the offset of its first record.
+func (s *segment) writeFrame(p []byte, attrs byte, records []*log_v1.Record) error {
+	_, pos, err := s.store.appendFrame(p, attrs)
 	if err != nil {
-		return 0, err
+		return err
+	}
+
+	if s.needsIndex(pos) {
+		if err = s.index.Write(uint32(records[0].Offset-s.baseOffset), pos); err != nil {
+			return err
+		}
+		s.indexedPos = pos
+	}
+
+	// the time index only needs the newest record of the frame
+	newest := records[0]
+	for _, record := range records[1:] {
+		if record.Timestamp > newest.Timestamp {
+			newest = record
+		}
+	}
+	if newest.Timestamp <= s.maxTimestamp {
+		return nil
+	}
+
+	s.maxTimestamp, s.maxTimestampOffset = newest.Timestamp, newest.Offset
+	if _, ok := s.timeIndex.last(); ok && pos-s.timeIndexedPos < s.config.Segment.TimeIndexIntervalBytes {
+		return nil
+	}
+
+	s.timeIndexedPos = pos
+	return s.timeIndex.Write(newest.Timestamp, uint32(newest.Offset-s.baseOffset))
+}
+
+// needsIndex tells whether the record written at pos gets an index entry. Dense indexes have an entry for every record,
+// sparse ones only when IndexIntervalBytes of store were written since the last entry.
+func (s *segment) needsIndex(pos uint64) bool {
+	interval := s.config.Segment.IndexIntervalBytes
+	return interval == 0 || s.index.size.Load() == 0 || pos-s.indexedPos >= interval
+}
+
+// offsetForTime returns the offset of the first record whose timestamp is at or after the given one. The caller must
+// make sure the segment holds such a record, i.e. that its max timestamp is not lower.
+func (s *segment) offsetForTime(timestamp int64) (uint64, error) {
+	// no record before the entry preceding the first one at or after the timestamp can match
+	off := s.baseOffset
+	if i := s.timeIndex.search(timestamp); i > 0 {
+		off += uint64(s.timeIndex.entries[i-1].off) + 1
 	}
 
-	// @todo extract this line to separate function
-	if err = s.index.Write(uint32(s.nextOffset-s.baseOffset), pos); err != nil {
+	out, pos, err := s.index.lookup(uint32(off - s.baseOffset))
+	if err != nil {
 		return 0, err
 	}
 
-	s.nextOffset++
-	return cur, nil
+	found := s.nextOffset.Load()
+	err = s.scan(s.baseOffset+uint64(out), pos, func(cur, pos uint64, _ frame, record *log_v1.Record) (bool, error) {
+		switch {
+		case cur < off:
+			return true, nil
+		case record == nil:
+			return false, s.corruptErr(cur, pos)
+		case record.Timestamp >= timestamp:
+			found = cur
+			return false, nil
+		}
+		return true, nil
+	})
+
+	return found, err
 }
 
+// Read returns the record stored under the offset, or the first record after it when the offset was removed by
+// compaction. It returns io.EOF when there is no such record in the segment.
 func (s *segment) Read(off uint64) (*log_v1.Record, error) {
-	// @todo extract this line to separate function
-	// translate the absolute index into a relative offset and get the associated index entry
-	_, pos, err := s.index.Read(int64(off - s.baseOffset))
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, errSegmentClosed
+	}
+
+	_, _, record, err := s.seek(off)
+	return record, err
+}
+
+// seek returns the first record at or after off along with its offset and store position, or io.EOF when there is no
+// such record in the segment.
+func (s *segment) seek(off uint64) (uint64, uint64, *log_v1.Record, error) {
+	// translate the absolute offset into a relative one and get the nearest preceding index entry
+	out, pos, err := s.index.lookup(uint32(off - s.baseOffset))
 	if err != nil {
-		return nil, err
+		return 0, 0, nil, err
 	}
 
-	p, err := s.store.Read(pos)
+	var (
+		cur, start uint64
+		record     *log_v1.Record
+	)
+	err = s.scan(s.baseOffset+uint64(out), pos, func(o, p uint64, _ frame, r *log_v1.Record) (bool, error) {
+		switch {
+		case o < off:
+			return true, nil
+		case r == nil:
+			return false, s.corruptErr(o, p)
+		}
+
+		cur, start, record = o, p, r
+		return false, nil
+	})
+	if err == nil && record == nil {
+		err = io.EOF
+	}
+
+	return cur, start, record, err
+}
+
+// readRange appends to p the frames of the records from the first one at or after off, as long as p stays within
+// maxBytes, though a frame is always read into an empty p. It returns the offsets of the first record at or after off
+// and of the one following the last record read, or io.EOF when there is no such record in the segment. A batch frame
+// is read whole, so the first frame may hold records before off. Only the first and the last frames are decoded, to
+// learn their offsets.
+func (s *segment) readRange(p []byte, off uint64, maxBytes int) ([]byte, uint64, uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return p, 0, 0, errSegmentClosed
+	}
+
+	first, start, _, err := s.seek(off)
 	if err != nil {
-		return nil, err
+		return p, 0, 0, err
 	}
 
-	record := &log_v1.Record{}
-	if err := proto.Unmarshal(p, record); err != nil {
-		return nil, err
+	// collect the positions of the frames which fit by their length prefixes alone
+	var (
+		positions []uint64
+		full      bool
+	)
+	end, size := start, s.publishedSize.Load()
+	for end < size {
+		width, err := s.store.frameWidth(end)
+		if err != nil {
+			return p, 0, 0, err
+		}
+		if end+width > size {
+			break
+		}
+		if full = (len(p) > 0 || end > start) && len(p)+int(end+width-start) > maxBytes; full {
+			break
+		}
+
+		positions = append(positions, end)
+		end += width
+	}
+	if len(positions) == 0 {
+		if full {
+			return p, first, first, nil
+		}
+		// the record is being appended
+		return p, 0, 0, io.EOF
+	}
+
+	// the last frame tells where the range ends, a damaged one is left for the next read to report while the first one
+	// holds the first record
+	next := first + 1
+	for i := len(positions) - 1; i >= 0; i-- {
+		f, err := s.store.readFrame(positions[i])
+		if err != nil && !errors.Is(err, errCorruptFrame) {
+			return p, 0, 0, err
+		}
+		if err == nil {
+			if records, err := decodeRecords(f, s.config.Encryption.Keyring); err == nil {
+				next = records[len(records)-1].Offset + 1
+				break
+			}
+		}
+		if i == 0 {
+			break
+		}
+		end = positions[i]
+	}
+
+	n := len(p)
+	p = slices.Grow(p, int(end-start))[:n+int(end-start)]
+	if _, err = s.store.File.ReadAt(p[n:], int64(start)); err != nil {
+		return p[:n], 0, 0, err
 	}
 
-	return record, nil
+	return p, first, next, nil
+}
+
+// scan walks the store from the frame at pos, which holds the record stored under off, and calls fn for every record
+// along with its offset and the position of its frame until fn returns false. Damaged frames are passed without record
+// and are assumed to hold the offset following the previous record.
+func (s *segment) scan(off, pos uint64, fn func(off, pos uint64, f frame, record *log_v1.Record) (bool, error)) error {
+	return s.scanFrames(off, pos, func(off, pos uint64, f frame, records []*log_v1.Record) (bool, error) {
+		if records == nil {
+			return fn(off, pos, f, nil)
+		}
+
+		for i, record := range records {
+			// the records of a batch keep their offsets
+			if i > 0 {
+				off = record.Offset
+			}
+			if more, err := fn(off, pos, f, record); err != nil || !more {
+				return more, err
+			}
+		}
+
+		return true, nil
+	})
+}
+
+// scanFrames is like scan, but it calls fn for every frame along with the offset of its first record and its records.
+func (s *segment) scanFrames(off, pos uint64, fn func(off, pos uint64, f frame, records []*log_v1.Record) (bool, error)) error {
+	for ; pos < s.store.size.Load(); off++ {
+		f, err := s.store.readFrame(pos)
+		if err != nil && !errors.Is(err, errCorruptFrame) {
+			return err
+		}
+
+		var records []*log_v1.Record
+		if err == nil {
+			records, err = decodeRecords(f, s.config.Encryption.Keyring)
+			switch {
+			case errors.Is(err, errUnknownKey):
+				// a record which cannot be decrypted is not damaged
+				return err
+			case err != nil:
+				// legacy frames have no checksum, so a failed decoding is the only hint that they were damaged
+				records = nil
+			case records[0].Offset > off:
+				// offsets are only skipped by compaction, which keeps them in the records
+				off = records[0].Offset
+			}
+		}
+
+		more, err := fn(off, pos, f, records)
+		if err != nil || !more {
+			return err
+		}
+
+		if f.width == 0 {
+			// the length prefix is damaged, so there is no telling where the next frame starts
+			return s.corruptErr(off, pos)
+		}
+		pos += f.width
+		if n := len(records); n > 1 {
+			off = records[n-1].Offset
+		}
+	}
+
+	return nil
+}
+
+func (s *segment) corruptErr(off, pos uint64) error {
+	return log_v1.ErrCorruptRecord{
+		Offset:     off,
+		BaseOffset: s.baseOffset,
+		Position:   pos,
+	}
 }
 
 // IsMaxed checks whether the segment (store or index) has reached its max size. It is used to know whether we need to
 // create new segment.
 //
 // if you write small amount of too big logs, then you would hit the store size limit
-// if you write big amount of too small logs, then you would hit the index size limit
+// if you write big amount of too small logs, then you would hit the index size limit, unless the index is sparse
 func (s *segment) IsMaxed() bool {
-	return s.store.size >= s.config.Segment.MaxStoreBytes || s.index.size >= s.config.Segment.MaxIndexBytes
+	return s.store.size.Load() >= s.config.Segment.MaxStoreBytes || s.index.size.Load() >= s.config.Segment.MaxIndexBytes
+}
+
+// size returns the disk space taken by the segment.
+func (s *segment) size() uint64 {
+	return s.store.size.Load() + s.index.size.Load()
+}
+
+// lastModified returns the timestamp of the newest record of the segment, or the time the store was last written to
+// when its records have no timestamps.
+func (s *segment) lastModified() (time.Time, error) {
+	if s.maxTimestamp != 0 {
+		return time.Unix(0, s.maxTimestamp), nil
+	}
+
+	info, err := s.store.Stat()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return info.ModTime(), nil
+}
+
+// Sync commits both the store and the index to stable storage.
+func (s *segment) Sync() error {
+	if err := s.store.Sync(); err != nil {
+		return err
+	}
+
+	if err := s.timeIndex.Sync(); err != nil {
+		return err
+	}
+
+	return s.index.Sync()
 }
 
 func (s *segment) Remove() error {
@@ -121,10 +616,33 @@ func (s *segment) Remove() error {
 		return err
 	}
 
+	if err := os.Remove(s.timeIndex.Name()); err != nil {
+		return err
+	}
+
+	if err := os.Remove(s.createdFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
 	return nil
 }
 
 func (s *segment) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	// index the highest timestamp, so it does not have to be looked up in the records when the segment is reopened
+	if last, ok := s.timeIndex.last(); !ok && s.maxTimestamp != 0 || ok && last.timestamp < s.maxTimestamp {
+		if err := s.timeIndex.Write(s.maxTimestamp, uint32(s.maxTimestampOffset-s.baseOffset)); err != nil {
+			return err
+		}
+	}
+
 	if err := s.store.Close(); err != nil {
 		return err
 	}
@@ -133,9 +651,129 @@ func (s *segment) Close() error {
 		return err
 	}
 
+	if err := s.timeIndex.Close(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// encodeRecord marshals the record and compresses it with the configured codec. It returns the payload of the frame
+// along with its attributes.
+func encodeRecord(record *log_v1.Record, c Config) ([]byte, byte, error) {
+	p, err := proto.Marshal(record)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return encodePayload(p, c)
+}
+
+// batched tells whether n records appended together are stored in a batch frame, a single record being stored alone.
+func batched(n int) bool {
+	return n > 1
+}
+
+// encodeBatch encodes the records into the payload of a single batch frame: the number of records followed by the
+// length prefixed records, compressed as a whole with the configured codec. The records must hold their offsets.
+func encodeBatch(records []*log_v1.Record, c Config) ([]byte, byte, error) {
+	p := protowire.AppendVarint(nil, uint64(len(records)))
+	for _, record := range records {
+		b, err := proto.Marshal(record)
+		if err != nil {
+			return nil, 0, err
+		}
+		p = protowire.AppendBytes(p, b)
+	}
+
+	p, attrs, err := encodePayload(p, c)
+	return p, attrs | attrBatch, err
+}
+
+// encodePayload compresses and encrypts the encoded records as configured and returns the payload of the frame along
+// with its attributes.
+func encodePayload(p []byte, c Config) ([]byte, byte, error) {
+	attrs := byte(CodecNone)
+	if codec := c.Compression.Codec; codec != CodecNone && len(p) >= c.Compression.MinBytes {
+		compressed, err := codec.compress(p)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		// keep the records as they are when the codec does not make them smaller
+		if len(compressed) < len(p) {
+			p, attrs = compressed, byte(codec)
+		}
+	}
+
+	// encrypted data does not compress, so records are compressed first
+	if c.Encryption.Keyring != nil {
+		var err error
+		if p, err = c.Encryption.Keyring.seal(p); err != nil {
+			return nil, 0, err
+		}
+		attrs |= attrEncrypted
+	}
+
+	return p, attrs, nil
+}
+
+// decodeRecords decrypts and decompresses the payload of the frame and unmarshals the records out of it, a single one
+// unless it is a batch frame. Encrypted records cannot be decoded without the keyring holding their key.
+func decodeRecords(f frame, keyring *Keyring) ([]*log_v1.Record, error) {
+	p := f.payload
+	if f.attrs&attrEncrypted != 0 {
+		if keyring == nil {
+			return nil, fmt.Errorf("%w: %d", errUnknownKey, keyID(p))
+		}
+
+		var err error
+		if p, err = keyring.open(p); err != nil {
+			return nil, err
+		}
+	}
+
+	p, err := Codec(f.attrs & attrCodecMask).decompress(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.attrs&attrBatch == 0 {
+		record := &log_v1.Record{}
+		if err := proto.Unmarshal(p, record); err != nil {
+			return nil, err
+		}
+
+		return []*log_v1.Record{record}, nil
+	}
+
+	n, w := protowire.ConsumeVarint(p)
+	// every record takes at least its length prefix
+	if w < 0 || n == 0 || n > uint64(len(p)) {
+		return nil, errCorruptFrame
+	}
+	p = p[w:]
+
+	records := make([]*log_v1.Record, n)
+	for i := range records {
+		b, w := protowire.ConsumeBytes(p)
+		if w < 0 {
+			return nil, errCorruptFrame
+		}
+		p = p[w:]
+
+		records[i] = &log_v1.Record{}
+		if err := proto.Unmarshal(b, records[i]); err != nil {
+			return nil, err
+		}
+	}
+	if len(p) != 0 {
+		return nil, errCorruptFrame
+	}
+
+	return records, nil
+}
+
 func nearestMultiple(j, k uint64) uint64 {
 	if j >= 0 {
 		return (j / k) * k
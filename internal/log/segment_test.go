@@ -21,7 +21,7 @@ func TestSegment(t *testing.T) {
 
 	s, err := newSegment(dir, 16, c)
 	require.NoError(t, err)
-	require.Equal(t, uint64(16), s.nextOffset, s.nextOffset)
+	require.Equal(t, uint64(16), s.nextOffset.Load(), s.nextOffset.Load())
 	require.False(t, s.IsMaxed())
 
 	for i := uint64(0); i < 3; i++ {
@@ -51,3 +51,37 @@ func TestSegment(t *testing.T) {
 	require.NoError(t, err)
 	require.False(t, s.IsMaxed())
 }
+
+func TestSegmentAppendBatchRollback(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "segment_test")
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	// room for the index entries of two frames
+	c.Segment.MaxIndexBytes = entWidth * 2
+
+	s, err := newSegment(dir, 0, c)
+	require.NoError(t, err)
+
+	record := &log_v1.Record{Value: []byte("hello world")}
+	_, err = s.Append(record)
+	require.NoError(t, err)
+
+	off, err := s.AppendBatch([]*log_v1.Record{record, record})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+	require.Equal(t, uint64(3), s.nextOffset.Load())
+	size := s.store.size.Load()
+
+	// the frame of the batch overflows the index
+	_, err = s.AppendBatch([]*log_v1.Record{record, record, record})
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, uint64(3), s.nextOffset.Load())
+	require.Equal(t, size, s.store.size.Load())
+	require.Equal(t, entWidth*2, s.index.size.Load())
+
+	got, err := s.Read(2)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), got.Offset)
+}
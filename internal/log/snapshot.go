@@ -0,0 +1,211 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+)
+
+const (
+	// snapshotHeaderWidth is the size of the snapshot header: the lowest offset of the log, the offset the next
+	// appended record gets and the size of the frames following it.
+	snapshotHeaderWidth = 24
+
+	// restoreDir holds the segments of a snapshot being restored until it is completely read.
+	restoreDir = ".restore"
+	// replaceFile marks the segments in the swap directory as replacing all the segments of the log.
+	replaceFile = ".replace"
+)
+
+// snapshotReader streams a snapshot from its own descriptors of the store files, which keep reading the segments once
+// they are removed or replaced.
+type snapshotReader struct {
+	io.Reader
+	files []*os.File
+}
+
+func (r *snapshotReader) Close() error {
+	var errs []error
+	for _, f := range r.files {
+		errs = append(errs, f.Close())
+	}
+	r.files = nil
+
+	return errors.Join(errs...)
+}
+
+// Snapshot returns a point-in-time copy of the local segments of the log: a header holding the lowest offset, the
+// offset of the next record and the size of the frames, followed by the store frames of the records as appended when
+// Snapshot was called. Records appended later are not part of it, and neither are the offloaded segments no longer
+// kept locally, the snapshot then starts at the lowest local offset. The log is not held by an open snapshot.
+func (l *Log) Snapshot() (io.ReadCloser, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	// the segments are only removed or replaced with the lock held, so their files are the ones of the list
+	segments := l.segments()
+	r := &snapshotReader{files: make([]*os.File, 0, len(segments))}
+	readers := make([]io.Reader, 0, len(segments)+1)
+	var size uint64
+	for _, seg := range segments {
+		f, err := os.Open(seg.store.Name())
+		if err != nil {
+			return nil, errors.Join(err, r.Close())
+		}
+		r.files = append(r.files, f)
+
+		n := seg.publishedSize.Load()
+		readers = append(readers, io.NewSectionReader(f, 0, int64(n)))
+		size += n
+	}
+
+	header := make([]byte, snapshotHeaderWidth)
+	enc.PutUint64(header, segments[0].baseOffset)
+	enc.PutUint64(header[8:], l.activeSegment.nextOffset.Load())
+	enc.PutUint64(header[16:], size)
+	r.Reader = io.MultiReader(append([]io.Reader{bytes.NewReader(header)}, readers...)...)
+
+	return r, nil
+}
+
+// Restore replaces the content of the log with the snapshot read from r, the records keeping their offsets. The
+// offloaded segments of the log are removed as well, once the restored segments replaced the original ones. The
+// snapshot is read completely into a staging directory first, so the log is left untouched when it is truncated or
+// cannot be decrypted. The log must not be appended to while restoring.
+func (l *Log) Restore(r io.Reader) error {
+	header := make([]byte, snapshotHeaderWidth)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	lowest, next, size := enc.Uint64(header), enc.Uint64(header[8:]), enc.Uint64(header[16:])
+
+	dir := path.Join(l.Dir, restoreDir)
+	if err := l.stage(dir, r, lowest, next, size); err != nil {
+		return errors.Join(err, os.RemoveAll(dir))
+	}
+
+	l.maintenance.Lock()
+	defer l.maintenance.Unlock()
+
+	// once renamed, the restored segments replace the original ones even if interrupted
+	if err := os.Rename(dir, path.Join(l.Dir, swapDir)); err != nil {
+		return errors.Join(err, os.RemoveAll(dir))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// the original segments keep reading their files once they are removed, until the readers holding them are done
+	if err := finishSwap(l.Dir); err != nil {
+		return err
+	}
+
+	// the log is restored even if its offloaded segments cannot all be removed
+	var terr error
+	if l.tier != nil {
+		terr = l.tier.removeAll()
+	}
+
+	segments, err := l.openSegments()
+	if err != nil {
+		return errors.Join(terr, err)
+	}
+	replaced := l.segments()
+	l.publish(segments)
+	l.activeSegment = segments[len(segments)-1]
+	l.unsyncedRecords, l.unsyncedBytes = 0, 0
+	l.cache.clear()
+
+	return errors.Join(terr, closeSegments(replaced))
+}
+
+// stage writes the segments of the snapshot read from r into dir, checking that it holds size bytes of frames of
+// records between lowest and next.
+func (l *Log) stage(dir string, r io.Reader, lowest, next, size uint64) (err error) {
+	if err = os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err = os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+
+	seg, err := newSegment(dir, lowest, l.Config)
+	if err != nil {
+		return err
+	}
+	segments := []*segment{seg}
+	defer func() {
+		if cerr := closeSegments(segments); err == nil {
+			err = cerr
+		}
+	}()
+
+	frames := &io.LimitedReader{R: r, N: int64(size)}
+	dec := NewDecoder(frames)
+	for {
+		f, err := dec.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		records, err := decodeRecords(f, l.Config.Encryption.Keyring)
+		if err != nil {
+			return err
+		}
+		from := seg.nextOffset.Load()
+		for _, record := range records {
+			if record.Offset < from || record.Offset >= next {
+				return fmt.Errorf("snapshot record offset %d out of order", record.Offset)
+			}
+			from = record.Offset + 1
+		}
+
+		if err = seg.restoreFrame(f, records); err != nil {
+			return err
+		}
+		if seg.IsMaxed() {
+			if seg, err = newSegment(dir, seg.nextOffset.Load(), l.Config); err != nil {
+				return err
+			}
+			segments = append(segments, seg)
+		}
+	}
+	// the snapshot may be cut right after a frame
+	if frames.N != 0 {
+		return io.ErrUnexpectedEOF
+	}
+
+	// the last records may have been compacted away, the next offset is kept by a segment starting there
+	if seg.nextOffset.Load() < next {
+		if seg, err = newSegment(dir, next, l.Config); err != nil {
+			return err
+		}
+		segments = append(segments, seg)
+	}
+
+	return os.WriteFile(path.Join(dir, replaceFile), nil, 0644)
+}
+
+// restoreFrame appends the frame of the records read from a snapshot under the offsets of the records, skipping the
+// offsets compaction removed before them, and publishes it.
+func (s *segment) restoreFrame(f frame, records []*log_v1.Record) error {
+	if err := s.writeFrame(f.payload, f.attrs, records); err != nil {
+		return err
+	}
+	if err := s.store.flush(); err != nil {
+		return err
+	}
+
+	s.nextOffset.Store(records[len(records)-1].Offset + 1)
+	s.publishedSize.Store(s.store.size.Load())
+
+	return nil
+}
@@ -0,0 +1,212 @@
+package log
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 3
+	src := newTestLog(t, c)
+	defer src.Remove()
+
+	appendKeyed(t, src,
+		"a", "1", "b", "1", "a", "2",
+		"c", "1", "b", "2", "", "x",
+		"a", "", "c", "2", "d", "1",
+		"e", "1",
+	)
+	require.NoError(t, src.Truncate(2))
+	_, err := src.Compact(compactionStart.Add(time.Minute))
+	require.NoError(t, err)
+
+	snapshot, err := src.Snapshot()
+	require.NoError(t, err)
+
+	// records appended after the snapshot was taken are not part of it
+	appendKeyed(t, src, "f", "1")
+
+	p, err := io.ReadAll(snapshot)
+	require.NoError(t, err)
+	require.NoError(t, snapshot.Close())
+
+	dst := newTestLog(t, c)
+	defer dst.Remove()
+	appendKeyed(t, dst, "z", "1")
+
+	require.NoError(t, dst.Restore(bytes.NewReader(p)))
+
+	lowest, err := dst.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), lowest)
+
+	// the offsets removed by compaction stay removed
+	requireRecord(t, dst, 3, 4, "b", "2")
+	requireRecord(t, dst, 5, 5, "", "x")
+	requireRecord(t, dst, 9, 9, "e", "1")
+	_, err = dst.Read(10)
+	require.Error(t, err)
+
+	off, err := dst.Append(&log_v1.Record{Value: []byte("y")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), off)
+}
+
+func TestSnapshotKeepsSegments(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 3
+	log := newTestLog(t, c)
+	defer log.Remove()
+
+	appendKeyed(t, log, "a", "1", "b", "1", "c", "1", "d", "1")
+	snapshot, err := log.Snapshot()
+	require.NoError(t, err)
+	defer snapshot.Close()
+
+	// the snapshot does not hold the log, its segments are still read once removed
+	require.NoError(t, log.Truncate(2))
+
+	_, err = io.ReadFull(snapshot, make([]byte, snapshotHeaderWidth))
+	require.NoError(t, err)
+	dec := NewDecoder(snapshot)
+	for off := uint64(0); off < 4; off++ {
+		record, err := dec.Decode()
+		require.NoError(t, err)
+		require.Equal(t, off, record.Offset)
+	}
+	_, err = dec.Decode()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestSnapshotOffloaded(t *testing.T) {
+	src := newTestLog(t, tieredConfig(t))
+	defer src.Remove()
+
+	appendRecordsAt(t, src, 7, time.Now())
+	_, err := src.Offload()
+	require.NoError(t, err)
+
+	// only the local segments are part of the snapshot
+	p := readSnapshot(t, src)
+	require.Equal(t, uint64(6), enc.Uint64(p))
+
+	dst := newTestLog(t, Config{})
+	defer dst.Remove()
+	require.NoError(t, dst.Restore(bytes.NewReader(p)))
+
+	lowest, err := dst.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), lowest)
+	record, err := dst.Read(6)
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), record.Offset)
+}
+
+func TestRestoreOffloaded(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 3
+	src := newTestLog(t, c)
+	defer src.Remove()
+	appendKeyed(t, src, "a", "1", "b", "1")
+	p := readSnapshot(t, src)
+
+	dst := newTestLog(t, tieredConfig(t))
+	defer dst.Remove()
+	appendRecordsAt(t, dst, 7, time.Now())
+	_, err := dst.Offload()
+	require.NoError(t, err)
+
+	// the offloaded segments are kept when the restored ones cannot replace the local ones
+	swap := path.Join(dst.Dir, swapDir)
+	require.NoError(t, os.MkdirAll(path.Join(swap, "stale"), 0755))
+	require.Error(t, dst.Restore(bytes.NewReader(p)))
+	record, err := dst.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), record.Offset)
+
+	require.NoError(t, os.RemoveAll(swap))
+	require.NoError(t, dst.Restore(bytes.NewReader(p)))
+	requireRecord(t, dst, 0, 0, "a", "1")
+	_, err = dst.Read(2)
+	require.Error(t, err)
+	require.Empty(t, dst.tier.remote)
+}
+
+func TestRestoreTruncated(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 3
+	src := newTestLog(t, c)
+	defer src.Remove()
+	appendKeyed(t, src, "a", "1", "b", "1", "c", "1", "d", "1")
+	p := readSnapshot(t, src)
+
+	dst := newTestLog(t, c)
+	defer dst.Remove()
+	appendKeyed(t, dst, "z", "1", "y", "1")
+
+	// cut within a frame and right after the header
+	for _, n := range []int{len(p) - 3, snapshotHeaderWidth} {
+		require.ErrorIs(t, dst.Restore(bytes.NewReader(p[:n])), io.ErrUnexpectedEOF)
+
+		requireRecord(t, dst, 0, 0, "z", "1")
+		requireRecord(t, dst, 1, 1, "y", "1")
+		_, err := dst.Read(2)
+		require.Error(t, err)
+		_, err = os.Stat(path.Join(dst.Dir, restoreDir))
+		require.ErrorIs(t, err, os.ErrNotExist)
+	}
+}
+
+func TestRestoreInterrupted(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 3
+	src := newTestLog(t, c)
+	defer src.Remove()
+	appendKeyed(t, src, "a", "1", "b", "1", "c", "1", "d", "1")
+	require.NoError(t, src.Truncate(2))
+	p := readSnapshot(t, src)
+
+	dst := newTestLog(t, c)
+	defer dst.Remove()
+	appendKeyed(t, dst, "z", "1", "y", "1", "x", "1", "w", "1", "v", "1")
+
+	// the restored segments are staged and committed, but the log stops before they replace the original ones
+	dir := path.Join(dst.Dir, restoreDir)
+	lowest, next, size := enc.Uint64(p), enc.Uint64(p[8:]), enc.Uint64(p[16:])
+	require.NoError(t, dst.stage(dir, bytes.NewReader(p[snapshotHeaderWidth:]), lowest, next, size))
+	require.NoError(t, os.Rename(dir, path.Join(dst.Dir, swapDir)))
+	require.NoError(t, dst.Close())
+
+	log, err := NewLog(dst.Dir, dst.Config)
+	require.NoError(t, err)
+	defer log.Close()
+
+	lowest, err = log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), lowest)
+	requireRecord(t, log, 3, 3, "d", "1")
+	_, err = log.Read(4)
+	require.Error(t, err)
+}
+
+// readSnapshot returns the content of a snapshot of the log.
+func readSnapshot(t *testing.T, log *Log) []byte {
+	t.Helper()
+
+	snapshot, err := log.Snapshot()
+	require.NoError(t, err)
+	defer snapshot.Close()
+
+	p, err := io.ReadAll(snapshot)
+	require.NoError(t, err)
+
+	return p
+}
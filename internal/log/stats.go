@@ -0,0 +1,103 @@
+package log
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of the log counters.
+type Stats struct {
+	// Syncs is the number of times the active segment was committed to stable storage.
+	Syncs uint64
+	// SyncTime is the total time spent syncing and MaxSyncTime is the slowest sync observed.
+	SyncTime    time.Duration
+	MaxSyncTime time.Duration
+	// RawBytes is the size of the appended records before compression and StoredBytes is what they take in the store,
+	// not counting the frame headers.
+	RawBytes    uint64
+	StoredBytes uint64
+	// GroupCommits is the number of groups of concurrent appends committed together and GroupedRecords the number of
+	// records they held.
+	GroupCommits   uint64
+	GroupedRecords uint64
+	// CacheHits and CacheMisses count the reads served from the record cache and the ones which went to the segments.
+	CacheHits   uint64
+	CacheMisses uint64
+}
+
+// AvgSyncTime returns the mean latency of a sync.
+func (s Stats) AvgSyncTime() time.Duration {
+	if s.Syncs == 0 {
+		return 0
+	}
+
+	return s.SyncTime / time.Duration(s.Syncs)
+}
+
+// CompressionRatio returns how many times the appended records shrank once stored, a batch being compressed as a whole.
+func (s Stats) CompressionRatio() float64 {
+	if s.StoredBytes == 0 {
+		return 1
+	}
+
+	return float64(s.RawBytes) / float64(s.StoredBytes)
+}
+
+type stats struct {
+	syncs       atomic.Uint64
+	syncTime    atomic.Int64
+	maxSyncTime atomic.Int64
+	rawBytes    atomic.Uint64
+	storedBytes atomic.Uint64
+
+	groupCommits   atomic.Uint64
+	groupedRecords atomic.Uint64
+
+	cacheHits   atomic.Uint64
+	cacheMisses atomic.Uint64
+}
+
+func (s *stats) observeAppend(raw, stored uint64) {
+	s.rawBytes.Add(raw)
+	s.storedBytes.Add(stored)
+}
+
+func (s *stats) observeGroupCommit(records int) {
+	s.groupCommits.Add(1)
+	s.groupedRecords.Add(uint64(records))
+}
+
+func (s *stats) observeCache(hit bool) {
+	if hit {
+		s.cacheHits.Add(1)
+	} else {
+		s.cacheMisses.Add(1)
+	}
+}
+
+func (s *stats) observeSync(d time.Duration) {
+	s.syncs.Add(1)
+	s.syncTime.Add(int64(d))
+	for {
+		max := s.maxSyncTime.Load()
+		if int64(d) <= max || s.maxSyncTime.CompareAndSwap(max, int64(d)) {
+			return
+		}
+	}
+}
+
+func (s *stats) snapshot() Stats {
+	return Stats{
+		Syncs:       s.syncs.Load(),
+		SyncTime:    time.Duration(s.syncTime.Load()),
+		MaxSyncTime: time.Duration(s.maxSyncTime.Load()),
+		RawBytes:    s.rawBytes.Load(),
+		StoredBytes: s.storedBytes.Load(),
+
+		GroupCommits:   s.groupCommits.Load(),
+		GroupedRecords: s.groupedRecords.Load(),
+
+		CacheHits:   s.cacheHits.Load(),
+		CacheMisses: s.cacheMisses.Load(),
+	}
+}
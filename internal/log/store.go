@@ -3,23 +3,55 @@ package log
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
+	"hash/crc32"
 	"os"
 	"sync"
+	"sync/atomic"
 )
 
 var (
 	enc = binary.BigEndian
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	// errCorruptFrame is returned when a frame fails its checksum or its length prefix points past the end of the store.
+	errCorruptFrame = errors.New("corrupt frame")
 )
 
 const (
 	lenWidth = 8
+	crcWidth = 4
+)
+
+// Frame attributes are kept in the most significant byte of the length prefix. Frames written before checksums were
+// introduced have no attributes at all, so a zero attribute byte means a legacy frame without checksum. A batch frame
+// holds several records, see encodeBatch.
+const (
+	attrChecksum  byte = 1 << 7
+	attrBatch     byte = 1 << 4
+	attrEncrypted byte = 1 << 3
+	attrCodecMask byte = 0x07
+
+	attrShift        = 56
+	lenMask   uint64 = 1<<attrShift - 1
 )
 
+// frame is a single entry of the store.
+type frame struct {
+	attrs   byte
+	payload []byte
+	// width is the number of bytes the frame occupies in the store, including the length prefix and the checksum
+	width uint64
+}
+
+// store is written by a single writer at a time, which holds mu. Readers do not take it: they only read frames which
+// were flushed to the file before being published, see flush.
 type store struct {
 	*os.File
 	mu   sync.Mutex
 	buf  *bufio.Writer
-	size uint64
+	size atomic.Uint64
 }
 
 func newStore(file *os.File) (*store, error) {
@@ -28,52 +60,114 @@ func newStore(file *os.File) (*store, error) {
 		return nil, err
 	}
 
-	size := uint64(info.Size())
-	return &store{
+	s := &store{
 		File: file,
-		size: size,
 		buf:  bufio.NewWriter(file),
-	}, nil
+	}
+	s.size.Store(uint64(info.Size()))
+
+	return s, nil
 }
 
+// Append writes p as a single checksummed frame: the length prefix, the CRC32 (Castagnoli) of p and p itself.
 func (s *store) Append(p []byte) (n uint64, pos uint64, err error) {
+	return s.appendFrame(p, 0)
+}
+
+// appendFrame is like Append, but it keeps the given attributes in the length prefix.
+func (s *store) appendFrame(p []byte, attrs byte) (n uint64, pos uint64, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	pos = s.size
-	if err := binary.Write(s.buf, enc, uint64(len(p))); err != nil {
+	pos = s.size.Load()
+	header := make([]byte, lenWidth+crcWidth)
+	enc.PutUint64(header, uint64(attrs|attrChecksum)<<attrShift|uint64(len(p)))
+	enc.PutUint32(header[lenWidth:], crc32.Checksum(p, crcTable))
+
+	w, err := s.buf.Write(header)
+	if err != nil {
 		return 0, 0, err
 	}
 
-	w, err := s.buf.Write(p)
+	pw, err := s.buf.Write(p)
 	if err != nil {
 		return 0, 0, err
 	}
 
-	w += lenWidth
-	s.size += uint64(w)
+	w += pw
+	s.size.Add(uint64(w))
 	return uint64(w), pos, nil
 }
 
+// Read returns the payload of the frame starting at pos. It returns errCorruptFrame if the checksum does not match.
 func (s *store) Read(pos uint64) ([]byte, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if err := s.buf.Flush(); err != nil {
+	if err := s.flush(); err != nil {
 		return nil, err
 	}
 
-	size := make([]byte, lenWidth)
-	if _, err := s.File.ReadAt(size, int64(pos)); err != nil {
-		return nil, err
+	f, err := s.readFrame(pos)
+	return f.payload, err
+}
+
+// readFrame reads the frame starting at pos, which must have been flushed. The width of the frame is known even when
+// it fails its checksum.
+func (s *store) readFrame(pos uint64) (frame, error) {
+	header := make([]byte, lenWidth)
+	if _, err := s.File.ReadAt(header, int64(pos)); err != nil {
+		return frame{}, err
+	}
+
+	attrs, size := decodeLen(enc.Uint64(header))
+	width := uint64(lenWidth)
+	if attrs&attrChecksum != 0 {
+		width += crcWidth
+	}
+
+	if pos+width+size > s.size.Load() {
+		return frame{}, errCorruptFrame
 	}
 
-	b := make([]byte, enc.Uint64(size))
+	b := make([]byte, width-lenWidth+size)
 	if _, err := s.File.ReadAt(b, int64(pos+lenWidth)); err != nil {
-		return nil, err
+		return frame{}, err
+	}
+
+	f := frame{attrs: attrs, payload: b, width: width + size}
+	if attrs&attrChecksum == 0 {
+		return f, nil
+	}
+
+	f.payload = b[crcWidth:]
+	if crc32.Checksum(f.payload, crcTable) != enc.Uint32(b) {
+		return frame{width: f.width}, errCorruptFrame
+	}
+
+	return f, nil
+}
+
+// frameWidth returns the width of the frame at pos out of its length prefix, without reading the frame itself. The
+// frame must be flushed.
+func (s *store) frameWidth(pos uint64) (uint64, error) {
+	header := make([]byte, lenWidth)
+	if _, err := s.File.ReadAt(header, int64(pos)); err != nil {
+		return 0, err
+	}
+
+	attrs, size := decodeLen(enc.Uint64(header))
+	width := lenWidth + size
+	if attrs&attrChecksum != 0 {
+		width += crcWidth
 	}
 
-	return b, nil
+	return width, nil
+}
+
+// flush writes the buffered frames to the file, so they can be read.
+func (s *store) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.buf.Flush()
 }
 
 func (s *store) ReadAt(p []byte, off int64) (int, error) {
@@ -87,6 +181,32 @@ func (s *store) ReadAt(p []byte, off int64) (int, error) {
 	return s.File.ReadAt(p, off)
 }
 
+// truncate discards everything after the first size bytes of the store.
+func (s *store) truncate(size uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+
+	if err := s.File.Truncate(int64(size)); err != nil {
+		return err
+	}
+
+	s.size.Store(size)
+	return nil
+}
+
+// Sync flushes the buffer and commits the store to stable storage.
+func (s *store) Sync() error {
+	if err := s.flush(); err != nil {
+		return err
+	}
+
+	return s.File.Sync()
+}
+
 func (s *store) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -95,5 +215,14 @@ func (s *store) Close() error {
 		return err
 	}
 
+	if err := s.File.Sync(); err != nil {
+		return err
+	}
+
 	return s.File.Close()
 }
+
+// decodeLen splits a length prefix into the frame attributes and the payload length.
+func decodeLen(v uint64) (attrs byte, size uint64) {
+	return byte(v >> attrShift), v & lenMask
+}
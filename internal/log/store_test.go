@@ -10,7 +10,7 @@ import (
 
 var (
 	write = []byte("hello, it is test")
-	width = uint64(len(write)) + lenWidth
+	width = uint64(len(write)) + lenWidth + crcWidth
 )
 
 func TestStoreAppendAndRead(t *testing.T) {
@@ -63,7 +63,10 @@ func testReadAt(t *testing.T, s *store) {
 		require.Equal(t, lenWidth, n)
 		off += int64(n)
 
-		size := enc.Uint64(b)
+		attrs, size := decodeLen(enc.Uint64(b))
+		require.Equal(t, attrChecksum, attrs)
+		off += crcWidth
+
 		b = make([]byte, size)
 		n, err = s.ReadAt(b, off)
 		require.NoError(t, err)
@@ -73,6 +76,50 @@ func testReadAt(t *testing.T, s *store) {
 	}
 }
 
+func TestStoreCorruption(t *testing.T) {
+	file, err := os.CreateTemp("", "test_store_corruption")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	st, err := newStore(file)
+	require.NoError(t, err)
+
+	_, pos, err := st.Append(write)
+	require.NoError(t, err)
+	require.NoError(t, st.buf.Flush())
+
+	// flip a bit of the payload
+	b := make([]byte, 1)
+	_, err = file.ReadAt(b, int64(width-1))
+	require.NoError(t, err)
+	b[0] ^= 1
+	_, err = file.WriteAt(b, int64(width-1))
+	require.NoError(t, err)
+
+	_, err = st.Read(pos)
+	require.ErrorIs(t, err, errCorruptFrame)
+}
+
+func TestStoreLegacyFrame(t *testing.T) {
+	file, err := os.CreateTemp("", "test_store_legacy")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	// frames written before checksums were introduced have a bare length prefix
+	legacy := make([]byte, lenWidth+len(write))
+	enc.PutUint64(legacy, uint64(len(write)))
+	copy(legacy[lenWidth:], write)
+	_, err = file.Write(legacy)
+	require.NoError(t, err)
+
+	st, err := newStore(file)
+	require.NoError(t, err)
+
+	read, err := st.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, write, read)
+}
+
 func TestStoreClose(t *testing.T) {
 	file, err := os.CreateTemp("", "test_store_close")
 	require.NoError(t, err)
@@ -0,0 +1,561 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+	"go.uber.org/zap"
+)
+
+const (
+	// remoteDir holds the offloaded segments fetched back from the object store for reading.
+	remoteDir = ".remote"
+
+	defaultOffloadInterval = time.Minute
+	defaultCachedSegments  = 2
+)
+
+// OffloadedSegment describes a segment handled by tiered storage.
+type OffloadedSegment struct {
+	BaseOffset uint64
+	NextOffset uint64
+	// Bytes is the disk space the segment takes locally.
+	Bytes uint64
+	// Uploaded tells the segment was copied to the object store and Removed that its local copy was deleted.
+	Uploaded bool
+	Removed  bool
+}
+
+// tier keeps track of the segments offloaded to the object store and reads the ones which are no longer on the local
+// disk. The store, time index and index files of a segment are uploaded as <base offset>.store, <base offset>.timeindex
+// and <base offset>.index, the index last, so a segment whose index is in the store is complete. The created file is
+// not uploaded, it only tells when to roll the active segment.
+type tier struct {
+	store  ObjectStore
+	dir    string
+	config Config
+
+	// mu guards the fields below, it is not held while fetching segments from the object store
+	mu sync.Mutex
+	// remote holds the base offsets of the offloaded segments in order
+	remote []uint64
+	// maxTimestamps holds the highest timestamp of the offloaded segments uploaded or fetched since startup
+	maxTimestamps map[uint64]int64
+	// cached holds the offloaded segments fetched for reading, the most recently read last
+	cached []*segment
+}
+
+func newTier(dir string, c Config) (*tier, error) {
+	// the fetched segments are only a cache
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	names, err := c.Tiering.Store.List("")
+	if err != nil {
+		return nil, err
+	}
+
+	t := &tier{store: c.Tiering.Store, dir: dir, config: c, maxTimestamps: make(map[uint64]int64)}
+	for _, name := range names {
+		if path.Ext(name) != ".index" {
+			continue
+		}
+
+		off, err := strconv.ParseUint(strings.TrimSuffix(name, ".index"), 10, 64)
+		if err != nil {
+			continue
+		}
+		t.remote = append(t.remote, off)
+	}
+	slices.Sort(t.remote)
+
+	return t, nil
+}
+
+func objectName(baseOffset uint64, ext string) string {
+	return fmt.Sprintf("%d%s", baseOffset, ext)
+}
+
+// uploaded tells whether the segment starting at the offset was offloaded.
+func (t *tier) uploaded(baseOffset uint64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := slices.BinarySearch(t.remote, baseOffset)
+	return ok
+}
+
+// lowest returns the base offset of the oldest offloaded segment.
+func (t *tier) lowest() (uint64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.remote) == 0 {
+		return 0, false
+	}
+
+	return t.remote[0], true
+}
+
+// upload copies the files of the closed segment to the object store.
+func (t *tier) upload(seg *segment) error {
+	store := io.NewSectionReader(seg.store.File, 0, int64(seg.publishedSize.Load()))
+	if err := t.store.Put(objectName(seg.baseOffset, ".store"), store); err != nil {
+		return err
+	}
+
+	timeIndex := io.NewSectionReader(seg.timeIndex.file, 0, int64(len(seg.timeIndex.entries))*int64(timeEntWidth))
+	if err := t.store.Put(objectName(seg.baseOffset, ".timeindex"), timeIndex); err != nil {
+		return err
+	}
+
+	// the index file is padded while open, its entries alone are uploaded
+	index := io.NewSectionReader(seg.index.file, 0, int64(seg.index.size.Load()))
+	if err := t.store.Put(objectName(seg.baseOffset, ".index"), index); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if i, ok := slices.BinarySearch(t.remote, seg.baseOffset); !ok {
+		t.remote = slices.Insert(t.remote, i, seg.baseOffset)
+	}
+	t.maxTimestamps[seg.baseOffset] = seg.maxTimestamp
+
+	return nil
+}
+
+// read returns the record stored under the offset, or the first one after it, out of the offloaded segments starting
+// before until, the lowest local offset. It returns io.EOF when there is no such record.
+func (t *tier) read(off, until uint64) (*log_v1.Record, error) {
+	var record *log_v1.Record
+	err := t.each(off, until, func(seg *segment) (err error) {
+		record, err = seg.Read(max(off, seg.baseOffset))
+		return err
+	})
+
+	return record, err
+}
+
+// readRange is like Log.ReadRange for the offloaded segments starting before until, the frames it returns come from
+// a single segment.
+func (t *tier) readRange(off, until uint64, maxBytes int) (frames []byte, first, next uint64, err error) {
+	err = t.each(off, until, func(seg *segment) (err error) {
+		frames, first, next, err = seg.readRange(nil, max(off, seg.baseOffset), maxBytes)
+		return err
+	})
+
+	return frames, first, next, err
+}
+
+// offsetForTime is like Log.OffsetForTime for the offloaded segments starting before until. It returns io.EOF when
+// none of them holds a record at or after the timestamp. The segments known to hold older records only are not fetched.
+func (t *tier) offsetForTime(timestamp int64, until uint64) (uint64, error) {
+	lowest, ok := t.lowest()
+	if !ok {
+		return 0, io.EOF
+	}
+	bases, err := t.bases(lowest, until)
+	if err != nil {
+		return 0, err
+	}
+
+	var off uint64
+	for _, baseOffset := range bases {
+		t.mu.Lock()
+		maxTimestamp, ok := t.maxTimestamps[baseOffset]
+		t.mu.Unlock()
+		if ok && maxTimestamp < timestamp {
+			continue
+		}
+
+		err = t.with(baseOffset, func(seg *segment) (err error) {
+			seg.mu.RLock()
+			defer seg.mu.RUnlock()
+
+			switch {
+			case seg.closed:
+				return errSegmentClosed
+			case seg.maxTimestamp < timestamp:
+				return io.EOF
+			}
+			off, err = seg.offsetForTime(timestamp)
+			return err
+		})
+		if err != io.EOF {
+			return off, err
+		}
+	}
+
+	return 0, io.EOF
+}
+
+// each calls fn with the offloaded segments holding the offsets from off to until, in order, as long as fn returns
+// io.EOF. The segments are fetched without holding the lock, a segment deleted meanwhile is skipped.
+func (t *tier) each(off, until uint64, fn func(seg *segment) error) error {
+	bases, err := t.bases(off, until)
+	if err != nil {
+		return err
+	}
+
+	for _, baseOffset := range bases {
+		if err := t.with(baseOffset, fn); err != io.EOF {
+			return err
+		}
+	}
+
+	return io.EOF
+}
+
+// bases returns the base offsets of the offloaded segments holding the offsets from off to until.
+func (t *tier) bases(off, until uint64) ([]uint64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	i := sort.Search(len(t.remote), func(i int) bool {
+		return t.remote[i] > off
+	}) - 1
+	if i < 0 {
+		lowest := until
+		if len(t.remote) > 0 {
+			lowest = min(lowest, t.remote[0])
+		}
+		return nil, log_v1.ErrOffsetOutOfRange{Offset: off, Lowest: lowest}
+	}
+
+	var bases []uint64
+	for ; i < len(t.remote) && t.remote[i] < until; i++ {
+		bases = append(bases, t.remote[i])
+	}
+
+	return bases, nil
+}
+
+// with calls fn with the offloaded segment starting at the offset. A segment evicted from the cache while fn reads it is
+// fetched again, fn is not called for a segment deleted from the object store, as if it returned io.EOF.
+func (t *tier) with(baseOffset uint64, fn func(seg *segment) error) error {
+	for {
+		seg, err := t.open(baseOffset)
+		if errors.Is(err, errSegmentClosed) {
+			return io.EOF
+		}
+		if err != nil {
+			return err
+		}
+
+		if err = fn(seg); !errors.Is(err, errSegmentClosed) {
+			return err
+		}
+	}
+}
+
+// open returns the offloaded segment starting at the offset, fetching it from the object store unless cached. It
+// returns errSegmentClosed when the segment was deleted from the object store.
+func (t *tier) open(baseOffset uint64) (*segment, error) {
+	t.mu.Lock()
+	seg := t.cachedSegment(baseOffset)
+	t.mu.Unlock()
+	if seg != nil {
+		return seg, nil
+	}
+
+	// every fetch has a directory of its own, so fetching the same segment concurrently is not an issue
+	dir, err := os.MkdirTemp(t.dir, "segment-")
+	if err != nil {
+		return nil, err
+	}
+	for _, ext := range []string{".store", ".timeindex", ".index"} {
+		err = t.fetch(dir, objectName(baseOffset, ext))
+		if ext == ".timeindex" && errors.Is(err, os.ErrNotExist) {
+			// the segment was offloaded before the time indexes were, its records tell their timestamps
+			err = nil
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		seg, err = newSegment(dir, baseOffset, t.config)
+	}
+	if err != nil {
+		if rerr := os.RemoveAll(dir); rerr != nil {
+			return nil, rerr
+		}
+		if !t.uploaded(baseOffset) {
+			return nil, errSegmentClosed
+		}
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := slices.BinarySearch(t.remote, baseOffset); !ok {
+		return nil, errors.Join(errSegmentClosed, removeFetched(seg))
+	}
+	// another reader fetched the segment meanwhile
+	if cached := t.cachedSegment(baseOffset); cached != nil {
+		return cached, removeFetched(seg)
+	}
+
+	t.maxTimestamps[baseOffset] = seg.maxTimestamp
+	t.cached = append(t.cached, seg)
+	if len(t.cached) > t.config.Tiering.CachedSegments {
+		evicted := t.cached[0]
+		t.cached = t.cached[1:]
+		// readers still holding the segment are waited for
+		if err = removeFetched(evicted); err != nil {
+			return nil, err
+		}
+	}
+
+	return seg, nil
+}
+
+// cachedSegment returns the cached segment starting at the offset, nil when there is none. The caller must hold the
+// lock.
+func (t *tier) cachedSegment(baseOffset uint64) *segment {
+	for i, seg := range t.cached {
+		if seg.baseOffset == baseOffset {
+			t.cached = append(slices.Delete(t.cached, i, i+1), seg)
+			return seg
+		}
+	}
+
+	return nil
+}
+
+// removeFetched deletes the segment fetched from the object store along with its directory.
+func removeFetched(seg *segment) error {
+	if err := seg.Remove(); err != nil {
+		return err
+	}
+
+	return os.Remove(path.Dir(seg.store.Name()))
+}
+
+// fetch copies the object to the file of the same name in dir.
+func (t *tier) fetch(dir, name string) error {
+	r, err := t.store.Get(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, err := os.Create(path.Join(dir, name))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// truncate deletes the offloaded segments which are not on the local disk anymore and whose offsets are all lower than
+// lowest, until being the lowest local offset.
+func (t *tier) truncate(lowest, until uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for len(t.remote) > 0 && t.remote[0] < until {
+		next := until
+		if len(t.remote) > 1 {
+			next = min(next, t.remote[1])
+		}
+		if next > lowest+1 {
+			break
+		}
+
+		if err := t.delete(t.remote[0]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// remove deletes the offloaded copy of the segment starting at the offset, if any.
+func (t *tier) remove(baseOffset uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := slices.BinarySearch(t.remote, baseOffset); !ok {
+		return nil
+	}
+
+	return t.delete(baseOffset)
+}
+
+// removeAll deletes all the offloaded segments.
+func (t *tier) removeAll() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for len(t.remote) > 0 {
+		if err := t.delete(t.remote[0]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// delete removes the segment from the object store and the cache. The caller must hold the lock.
+func (t *tier) delete(baseOffset uint64) error {
+	// the index goes first, so the segment is not seen as complete anymore
+	for _, ext := range []string{".index", ".timeindex", ".store"} {
+		if err := t.store.Delete(objectName(baseOffset, ext)); err != nil {
+			return err
+		}
+	}
+
+	i, _ := slices.BinarySearch(t.remote, baseOffset)
+	t.remote = slices.Delete(t.remote, i, i+1)
+	delete(t.maxTimestamps, baseOffset)
+
+	for i, seg := range t.cached {
+		if seg.baseOffset == baseOffset {
+			t.cached = slices.Delete(t.cached, i, i+1)
+			return removeFetched(seg)
+		}
+	}
+
+	return nil
+}
+
+// close closes the cached segments.
+func (t *tier) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, seg := range t.cached {
+		if err := seg.Close(); err != nil {
+			return err
+		}
+	}
+	t.cached = nil
+
+	return nil
+}
+
+// Offload uploads the closed segments to the object store of tiered storage, then removes the local copies of the
+// oldest uploaded ones while the segments take more than the local max bytes. The active segment always stays local.
+// Offload does nothing unless tiered storage is enabled.
+func (l *Log) Offload() ([]OffloadedSegment, error) {
+	l.maintenance.Lock()
+	defer l.maintenance.Unlock()
+
+	if l.tier == nil {
+		return nil, nil
+	}
+
+	// the closed segments are not removed nor rewritten while holding maintenance, so they are uploaded unlocked
+	l.mu.RLock()
+	segments, active := l.segments(), l.activeSegment
+	l.mu.RUnlock()
+
+	var offloaded []OffloadedSegment
+	for _, seg := range segments {
+		if seg == active || l.tier.uploaded(seg.baseOffset) {
+			continue
+		}
+
+		if err := l.tier.upload(seg); err != nil {
+			return offloaded, err
+		}
+		offloaded = append(offloaded, OffloadedSegment{
+			BaseOffset: seg.baseOffset,
+			NextOffset: seg.nextOffset.Load(),
+			Bytes:      seg.size(),
+			Uploaded:   true,
+		})
+	}
+
+	maxBytes := l.Config.Tiering.LocalMaxBytes
+	if maxBytes == 0 {
+		return offloaded, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var size uint64
+	for _, seg := range l.segments() {
+		size += seg.size()
+	}
+
+	for len(l.segments()) > 1 && size > maxBytes && l.tier.uploaded(l.segments()[0].baseOffset) {
+		seg := l.segments()[0]
+		info := OffloadedSegment{
+			BaseOffset: seg.baseOffset,
+			NextOffset: seg.nextOffset.Load(),
+			Bytes:      seg.size(),
+			Removed:    true,
+		}
+
+		// readers still holding the segment are waited for by Remove
+		l.publish(l.segments()[1:])
+		if err := seg.Remove(); err != nil {
+			return offloaded, err
+		}
+		size -= info.Bytes
+
+		if i := slices.IndexFunc(offloaded, func(o OffloadedSegment) bool { return o.BaseOffset == info.BaseOffset }); i >= 0 {
+			offloaded[i].Removed = true
+		} else {
+			offloaded = append(offloaded, info)
+		}
+	}
+
+	return offloaded, nil
+}
+
+// offloadLoop periodically offloads the closed segments until closing is closed.
+func (l *Log) offloadLoop(closing <-chan struct{}) {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.Config.Tiering.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closing:
+			return
+		case <-ticker.C:
+			offloaded, err := l.Offload()
+			for _, seg := range offloaded {
+				l.logger.Info(
+					"offloaded segment",
+					zap.Uint64("base_offset", seg.BaseOffset),
+					zap.Uint64("next_offset", seg.NextOffset),
+					zap.Uint64("bytes", seg.Bytes),
+					zap.Bool("uploaded", seg.Uploaded),
+					zap.Bool("removed", seg.Removed),
+				)
+			}
+
+			if err != nil {
+				l.logger.Error("failed to offload segments", zap.Error(err))
+			}
+		}
+	}
+}
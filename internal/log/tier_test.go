@@ -0,0 +1,216 @@
+package log
+
+import (
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+)
+
+func TestOffload(t *testing.T) {
+	c := tieredConfig(t)
+	store := c.Tiering.Store
+	log := newTestLog(t, c)
+
+	appendKeyed(t, log,
+		"a", "0", "a", "1", "a", "2",
+		"a", "3", "a", "4", "a", "5",
+		"a", "6", "a", "7", "a", "8",
+		"a", "9",
+	)
+	require.Len(t, log.segments(), 4)
+
+	offloaded, err := log.Offload()
+	require.NoError(t, err)
+	require.Len(t, offloaded, 3)
+	for i, seg := range offloaded {
+		require.Equal(t, uint64(3*i), seg.BaseOffset)
+		require.True(t, seg.Uploaded)
+		require.True(t, seg.Removed)
+	}
+
+	// the local disk only keeps the active segment, the other offsets are read from the store
+	require.Len(t, log.segments(), 1)
+	lowest, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), lowest)
+
+	for _, off := range []uint64{0, 4, 7, 1, 9} {
+		requireRecord(t, log, off, off, "a", string(rune('0'+off)))
+	}
+
+	frames, first, next, err := log.ReadRange(1, 1<<20)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), first)
+	require.Equal(t, uint64(3), next)
+	require.Equal(t, []uint64{1, 2}, decodeOffsets(t, frames))
+
+	// nothing is left to offload
+	offloaded, err = log.Offload()
+	require.NoError(t, err)
+	require.Empty(t, offloaded)
+
+	// the offloaded segments are found again on restart
+	require.NoError(t, log.Close())
+	log, err = NewLog(log.Dir, c)
+	require.NoError(t, err)
+	requireRecord(t, log, 4, 4, "a", "4")
+
+	require.NoError(t, log.Truncate(4))
+	lowest, err = log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), lowest)
+	names, err := store.List("")
+	require.NoError(t, err)
+	require.Equal(t, []string{"3.index", "3.store", "3.timeindex", "6.index", "6.store", "6.timeindex"}, names)
+
+	require.NoError(t, log.Remove())
+	names, err = store.List("")
+	require.NoError(t, err)
+	require.Empty(t, names)
+}
+
+func TestOffloadConcurrentReads(t *testing.T) {
+	log := newTestLog(t, tieredConfig(t))
+	defer log.Remove()
+
+	appendRecords(t, log, 10)
+	_, err := log.Offload()
+	require.NoError(t, err)
+	require.Len(t, log.segments(), 1)
+
+	// a single segment is cached, so the readers keep evicting the segments the others read
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				off := uint64(i+j*3) % 9
+				record, err := log.Read(off)
+				if assert.NoError(t, err) {
+					assert.Equal(t, off, record.Offset)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestOffloadOffsetForTime(t *testing.T) {
+	log := newTestLog(t, tieredConfig(t))
+	defer log.Remove()
+
+	start := time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		_, err := log.Append(&log_v1.Record{Value: []byte("hello world"), Timestamp: start.Add(time.Duration(i) * time.Minute).UnixNano()})
+		require.NoError(t, err)
+	}
+	_, err := log.Offload()
+	require.NoError(t, err)
+	require.Len(t, log.segments(), 1)
+
+	for _, c := range []struct {
+		at   time.Time
+		want uint64
+	}{
+		{at: start.Add(-time.Hour), want: 0},
+		{at: start.Add(4 * time.Minute), want: 4},
+		{at: start.Add(4*time.Minute + time.Second), want: 5},
+		{at: start.Add(9 * time.Minute), want: 9},
+		{at: start.Add(time.Hour), want: 10},
+	} {
+		off, err := log.OffsetForTime(c.at)
+		require.NoError(t, err)
+		require.Equal(t, c.want, off, c.at)
+	}
+}
+
+func TestOffloadRewrittenSegment(t *testing.T) {
+	c := tieredConfig(t)
+	c.Tiering.LocalMaxBytes = 0
+	store := c.Tiering.Store
+	log := newTestLog(t, c)
+	defer log.Remove()
+
+	appendKeyed(t, log,
+		"a", "1", "b", "1", "a", "2",
+		"c", "1",
+	)
+	offloaded, err := log.Offload()
+	require.NoError(t, err)
+	require.Len(t, offloaded, 1)
+
+	// compaction drops the first record of the uploaded segment, its remote copy is stale
+	compacted, err := log.Compact(compactionStart.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, compacted, 1)
+	names, err := store.List("")
+	require.NoError(t, err)
+	require.Empty(t, names)
+
+	offloaded, err = log.Offload()
+	require.NoError(t, err)
+	require.Len(t, offloaded, 1)
+	require.Equal(t, uint64(0), offloaded[0].BaseOffset)
+
+	r, err := store.Get(objectName(0, ".store"))
+	require.NoError(t, err)
+	defer r.Close()
+	remote, err := io.ReadAll(r)
+	require.NoError(t, err)
+	local, err := os.ReadFile(log.segments()[0].store.Name())
+	require.NoError(t, err)
+	require.Equal(t, local, remote)
+}
+
+func TestOffloadRetention(t *testing.T) {
+	c := tieredConfig(t)
+	c.Tiering.LocalMaxBytes = 0
+	c.Retention.MaxAge = time.Hour
+	log := newTestLog(t, c)
+	defer log.Remove()
+
+	appendRecordsAt(t, log, 7, time.Now().Add(-2*time.Hour))
+	require.Len(t, log.segments(), 3)
+
+	// the segments are kept until offloaded
+	removed, err := log.ApplyRetention(time.Now())
+	require.NoError(t, err)
+	require.Empty(t, removed)
+
+	_, err = log.Offload()
+	require.NoError(t, err)
+	removed, err = log.ApplyRetention(time.Now())
+	require.NoError(t, err)
+	require.Len(t, removed, 2)
+	require.Len(t, log.segments(), 1)
+
+	for off := uint64(0); off < 7; off++ {
+		record, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, record.Offset)
+	}
+}
+
+// tieredConfig returns a configuration offloading the closed segments to a local object store and keeping only the
+// active segment on the local disk, with three records per segment.
+func tieredConfig(t *testing.T) Config {
+	t.Helper()
+
+	store, err := NewLocalObjectStore(t.TempDir())
+	require.NoError(t, err)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 3
+	c.Tiering.Store = store
+	c.Tiering.LocalMaxBytes = 1
+	c.Tiering.CachedSegments = 1
+
+	return c
+}
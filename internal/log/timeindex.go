@@ -0,0 +1,110 @@
+package log
+
+import (
+	"io"
+	"os"
+	"sort"
+)
+
+var (
+	tsWidth      uint64 = 8
+	timeEntWidth        = tsWidth + offWidth
+)
+
+type timeEntry struct {
+	timestamp int64
+	off       uint32
+}
+
+// timeIndex maps the timestamps of a segment to relative offsets. It is sparse: an entry is added only when a record
+// raises the highest timestamp of the segment, so the timestamps of the entries never decrease and every entry tells
+// that no record before its offset has a greater timestamp.
+type timeIndex struct {
+	file    *os.File
+	entries []timeEntry
+}
+
+func newTimeIndex(f *os.File) (*timeIndex, error) {
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &timeIndex{file: f}
+	for pos := uint64(0); pos+timeEntWidth <= uint64(len(b)); pos += timeEntWidth {
+		e := timeEntry{
+			timestamp: int64(enc.Uint64(b[pos : pos+tsWidth])),
+			off:       enc.Uint32(b[pos+tsWidth : pos+timeEntWidth]),
+		}
+		// a decreasing entry can only come from a damaged file, nothing after it can be trusted
+		if last, ok := t.last(); ok && (e.timestamp < last.timestamp || e.off < last.off) {
+			break
+		}
+		t.entries = append(t.entries, e)
+	}
+
+	// drop a torn entry or whatever follows a damaged one
+	if size := int64(len(t.entries)) * int64(timeEntWidth); size != int64(len(b)) {
+		if err := f.Truncate(size); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+func (t *timeIndex) Write(timestamp int64, off uint32) error {
+	b := make([]byte, timeEntWidth)
+	enc.PutUint64(b[:tsWidth], uint64(timestamp))
+	enc.PutUint32(b[tsWidth:], off)
+	if _, err := t.file.Write(b); err != nil {
+		return err
+	}
+
+	t.entries = append(t.entries, timeEntry{timestamp: timestamp, off: off})
+	return nil
+}
+
+func (t *timeIndex) last() (timeEntry, bool) {
+	if len(t.entries) == 0 {
+		return timeEntry{}, false
+	}
+
+	return t.entries[len(t.entries)-1], true
+}
+
+// search returns the position of the first entry whose timestamp is at or after the given one.
+func (t *timeIndex) search(timestamp int64) int {
+	return sort.Search(len(t.entries), func(i int) bool {
+		return t.entries[i].timestamp >= timestamp
+	})
+}
+
+// truncate drops the entries pointing at the given relative offset or after it.
+func (t *timeIndex) truncate(off uint32) error {
+	n := sort.Search(len(t.entries), func(i int) bool {
+		return t.entries[i].off >= off
+	})
+	if n == len(t.entries) {
+		return nil
+	}
+
+	if err := t.file.Truncate(int64(n) * int64(timeEntWidth)); err != nil {
+		return err
+	}
+
+	t.entries = t.entries[:n]
+	return nil
+}
+
+func (t *timeIndex) Name() string {
+	return t.file.Name()
+}
+
+func (t *timeIndex) Sync() error {
+	return t.file.Sync()
+}
+
+func (t *timeIndex) Close() error {
+	return t.file.Close()
+}
@@ -0,0 +1,50 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeIndex(t *testing.T) {
+	file, err := os.CreateTemp(os.TempDir(), "timeindex_test")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	idx, err := newTimeIndex(file)
+	require.NoError(t, err)
+	_, ok := idx.last()
+	require.False(t, ok)
+
+	entries := []timeEntry{
+		{timestamp: 10, off: 0},
+		{timestamp: 20, off: 3},
+		{timestamp: 30, off: 7},
+	}
+	for _, e := range entries {
+		require.NoError(t, idx.Write(e.timestamp, e.off))
+	}
+
+	require.Equal(t, 0, idx.search(5))
+	require.Equal(t, 1, idx.search(11))
+	require.Equal(t, 1, idx.search(20))
+	require.Equal(t, 3, idx.search(31))
+
+	// a torn entry is dropped when the index is reopened
+	_, err = file.Write([]byte{1, 2, 3})
+	require.NoError(t, err)
+	require.NoError(t, idx.Close())
+
+	file, err = os.OpenFile(file.Name(), os.O_RDWR|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	idx, err = newTimeIndex(file)
+	require.NoError(t, err)
+	require.Equal(t, entries, idx.entries)
+
+	require.NoError(t, idx.truncate(4))
+	require.Equal(t, entries[:2], idx.entries)
+	info, err := os.Stat(file.Name())
+	require.NoError(t, err)
+	require.Equal(t, int64(2*timeEntWidth), info.Size())
+}
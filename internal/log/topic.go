@@ -0,0 +1,212 @@
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"sync/atomic"
+
+	log_v1 "github.com/vlamug/pdlog/api/v1"
+)
+
+const (
+	// topicFile holds the metadata of a topic.
+	topicFile = "topic.json"
+	// creatingPrefix starts the name of the directory of a topic being created, which is renamed once complete.
+	creatingPrefix = ".creating-"
+)
+
+// topicMetadata describes a topic, it is kept in its directory.
+type topicMetadata struct {
+	Partitions int `json:"partitions"`
+}
+
+// TopicInfo describes a topic, as listed by LogManager.
+type TopicInfo struct {
+	Name       string
+	Partitions int
+}
+
+// Topic is a stream of records split into partitions, each one an independent log kept in a subdirectory of the topic
+// named after its number. Records with the same key go to the same partition, so their order is kept, records without
+// key are spread over the partitions in turn.
+type Topic struct {
+	Name string
+	Dir  string
+
+	partitions []*Log
+	// next counts the records without key, it picks their partition
+	next atomic.Uint64
+}
+
+// Partitions returns the number of partitions of the topic.
+func (t *Topic) Partitions() int {
+	return len(t.partitions)
+}
+
+// Partition returns the log of the partition.
+func (t *Topic) Partition(p int) (*Log, error) {
+	if p < 0 || p >= len(t.partitions) {
+		return nil, log_v1.ErrPartitionNotFound{Topic: t.Name, Partition: uint32(p)}
+	}
+
+	return t.partitions[p], nil
+}
+
+// PartitionFor returns the partition a record with the given key is produced to.
+func (t *Topic) PartitionFor(key []byte) int {
+	n := uint64(len(t.partitions))
+	if len(key) == 0 {
+		return int((t.next.Add(1) - 1) % n)
+	}
+
+	h := fnv.New32a()
+	h.Write(key)
+
+	return int(uint64(h.Sum32()) % n)
+}
+
+// close closes the logs of the partitions.
+func (t *Topic) close() error {
+	var errs []error
+	for _, l := range t.partitions {
+		errs = append(errs, l.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// remove deletes the partitions along with the directory of the topic.
+func (t *Topic) remove() error {
+	for _, l := range t.partitions {
+		if err := l.Remove(); err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(t.Dir)
+}
+
+// createTopicDir creates the directory of a topic along with the ones of its partitions. They are prepared aside and
+// renamed at once, so a topic is never seen partially created.
+func createTopicDir(dir, topic string, partitions int) error {
+	tmp := path.Join(dir, creatingPrefix+topic)
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.Mkdir(tmp, 0755); err != nil {
+		return err
+	}
+
+	for p := 0; p < partitions; p++ {
+		if err := os.Mkdir(path.Join(tmp, strconv.Itoa(p)), 0755); err != nil {
+			return err
+		}
+	}
+	if err := writeTopicMetadata(tmp, topicMetadata{Partitions: partitions}); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path.Join(dir, topic))
+}
+
+// migrateTopicDir brings the topic kept in dir up to date. A topic without metadata was created before topics were
+// partitioned, its log is moved into the first partition.
+func migrateTopicDir(dir string) error {
+	_, err := os.Stat(path.Join(dir, topicFile))
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if _, err = moveLog(dir, path.Join(dir, "0")); err != nil {
+		return err
+	}
+
+	return writeTopicMetadata(dir, topicMetadata{Partitions: 1})
+}
+
+// loadTopicMetadata reads the metadata of the topic kept in dir.
+func loadTopicMetadata(dir string) (topicMetadata, error) {
+	var m topicMetadata
+	p, err := os.ReadFile(path.Join(dir, topicFile))
+	if err != nil {
+		return m, err
+	}
+
+	if err = json.Unmarshal(p, &m); err != nil {
+		return m, fmt.Errorf("%w: %v", ErrIncompatibleFormat, err)
+	}
+	if m.Partitions < 1 {
+		return m, fmt.Errorf("%w: %d partitions", ErrIncompatibleFormat, m.Partitions)
+	}
+
+	return m, nil
+}
+
+func writeTopicMetadata(dir string, m topicMetadata) error {
+	p, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	name := path.Join(dir, topicFile)
+	if err = os.WriteFile(name+".tmp", p, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(name+".tmp", name)
+}
+
+// moveLog moves the files of the log kept in the from directory into the to one and returns how many were moved.
+// Directories without leading dot are not part of a log, so they are left in place, and so is the lock file, which
+// locks the from directory once moved. A move interrupted by a crash is carried on when moving again.
+func moveLog(from, to string) (int, error) {
+	files, err := os.ReadDir(from)
+	if err != nil {
+		return 0, err
+	}
+
+	var moved []string
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() && name[0] != '.' || name == lockFile {
+			continue
+		}
+		if _, _, ok, err := segmentFile(name); ok && err == nil || slices.Contains(dataDirEntries, name) {
+			moved = append(moved, name)
+		}
+	}
+	if len(moved) == 0 {
+		return 0, nil
+	}
+
+	if err = os.MkdirAll(to, 0755); err != nil {
+		return 0, err
+	}
+	for _, name := range moved {
+		if err = os.Rename(path.Join(from, name), path.Join(to, name)); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(moved), nil
+}
+
+// objectPrefix returns where the partition keeps its offloaded segments in the object store shared by all of them. The
+// first partition of a topic keeps the names used before topics were partitioned, which are the ones used before
+// topics were introduced for the default topic.
+func objectPrefix(topic string, p int) string {
+	switch {
+	case p != 0:
+		return fmt.Sprintf("%s/%d/", topic, p)
+	case topic == DefaultTopic:
+		return ""
+	default:
+		return topic + "/"
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errNoGroups is returned by the consumer group operations of a server which does not keep committed offsets or does
+// not coordinate the group members.
+var errNoGroups = status.Error(codes.Unimplemented, "consumer groups are not supported")
+
+// OffsetManager keeps the offsets committed by the consumer groups, the empty topic being the default one.
+type OffsetManager interface {
+	// CommitOffset stores the offset of the next record the group consumes from the partition.
+	CommitOffset(group, topic string, partition uint32, offset uint64) error
+	// FetchOffset returns the offset committed by the group for the partition, ErrOffsetNotCommitted when there is
+	// none.
+	FetchOffset(group, topic string, partition uint32) (uint64, error)
+}
+
+// TopicPartitions lists partitions of a topic.
+type TopicPartitions struct {
+	Topic      string
+	Partitions []uint32
+}
+
+// Membership is what a member gets on joining its group.
+type Membership struct {
+	MemberID   string
+	Generation uint64
+	Assignment []TopicPartitions
+}
+
+// GroupCoordinator assigns the partitions of the topics to the members of the consumer groups. The group is rebalanced,
+// and its generation bumped, whenever its members change, the members whose session timed out included.
+type GroupCoordinator interface {
+	// JoinGroup adds the member to the group, a new member joining without id. The strategy and a zero session timeout
+	// mean the configured ones.
+	JoinGroup(group, memberID string, topics []string, strategy string, sessionTimeout time.Duration) (Membership, error)
+	// Heartbeat keeps the member in the group, ErrIllegalGeneration telling it to join again.
+	Heartbeat(group, memberID string, generation uint64) error
+	LeaveGroup(group, memberID string) error
+	// CommitOffset commits the offset for the member in the generation, the members of a rebalanced group failing with
+	// ErrIllegalGeneration. Groups without members commit without member id.
+	CommitOffset(group, memberID string, generation uint64, topic string, partition uint32, offset uint64) error
+}
+
+// commitOffset commits the offset of the request through the group coordinator when there is one, so the commits of
+// stale members are rejected.
+func (c *Config) commitOffset(group, memberID string, generation uint64, topic string, partition uint32, offset uint64) error {
+	switch {
+	case c.OffsetManager == nil:
+		return errNoGroups
+	case c.GroupCoordinator != nil:
+		return c.GroupCoordinator.CommitOffset(group, memberID, generation, topic, partition, offset)
+	case memberID != "":
+		return errNoGroups
+	default:
+		return c.OffsetManager.CommitOffset(group, topic, partition, offset)
+	}
+}
@@ -2,20 +2,40 @@ package server
 
 import (
 	"context"
+	"time"
 
 	"github.com/vlamug/pdlog/api/v1"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type CommitLog interface {
 	Append(*api.Record) (uint64, error)
+	AppendBatch([]*api.Record) ([]uint64, error)
 	Read(uint64) (*api.Record, error)
+	OffsetForTime(time.Time) (uint64, error)
+	ReadRange(from uint64, maxBytes int) ([]byte, uint64, uint64, error)
 }
 
+// errNoTopics is returned by the topic operations of a server serving a single commit log.
+var errNoTopics = status.Error(codes.Unimplemented, "topics are not supported")
+
+// defaultReadRangeMaxBytes bounds the frames returned by ReadRange when the request does not.
+const defaultReadRangeMaxBytes = 1 << 20
+
 var _ api.LogServer = (*grpcServer)(nil)
 
 type Config struct {
+	// CommitLog serves the requests of the default topic when there is no TopicManager.
 	CommitLog CommitLog
+	// TopicManager routes the requests to the partitions of their topics.
+	TopicManager TopicManager
+	// OffsetManager keeps the offsets committed by the consumer groups, nil disables the consumer group operations.
+	OffsetManager OffsetManager
+	// GroupCoordinator assigns the partitions to the members of the consumer groups, nil disables the membership
+	// operations.
+	GroupCoordinator GroupCoordinator
 }
 
 type grpcServer struct {
@@ -39,25 +59,193 @@ func newGRPCServer(config *Config) (*grpcServer, error) {
 }
 
 func (s *grpcServer) Produce(_ context.Context, req *api.ProduceRequest) (*api.ProduceResponse, error) {
-	record := &api.Record{
-		Value:  req.Record.Value,
-		Offset: req.Record.Offset,
+	t, err := s.topic(req.Topic, true)
+	if err != nil {
+		return nil, err
+	}
+
+	record := copyRecord(req.Record)
+	p := t.PartitionFor(record)
+	clog, err := t.Partition(p)
+	if err != nil {
+		return nil, err
 	}
-	offset, err := s.CommitLog.Append(record)
+
+	offset, err := clog.Append(record)
 	if err != nil {
 		return nil, err
 	}
 
-	return &api.ProduceResponse{Offset: offset}, nil
+	return &api.ProduceResponse{Offset: offset, Partition: p}, nil
+}
+
+func (s *grpcServer) ProduceBatch(_ context.Context, req *api.ProduceBatchRequest) (*api.ProduceBatchResponse, error) {
+	records := make([]*api.Record, len(req.Records))
+	for i, record := range req.Records {
+		records[i] = copyRecord(record)
+	}
+
+	offsets, partitions, err := s.produceBatch(req.Topic, records)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.ProduceBatchResponse{Offsets: offsets, Partitions: partitions}, nil
 }
 
 func (s *grpcServer) Consume(_ context.Context, req *api.ConsumeRequest) (*api.ConsumeResponse, error) {
-	record, err := s.CommitLog.Read(req.Offset)
+	clog, err := s.commitLog(req.Topic, req.Partition)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := clog.Read(req.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.ConsumeResponse{Record: record}, nil
+}
+
+func (s *grpcServer) OffsetForTime(_ context.Context, req *api.OffsetForTimeRequest) (*api.OffsetForTimeResponse, error) {
+	clog, err := s.commitLog(req.Topic, req.Partition)
+	if err != nil {
+		return nil, err
+	}
+
+	offset, err := clog.OffsetForTime(time.Unix(0, req.Timestamp))
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.OffsetForTimeResponse{Offset: offset}, nil
+}
+
+// ReadRange returns the records from the requested offset as stored by the log, for the client to decode them.
+func (s *grpcServer) ReadRange(_ context.Context, req *api.ReadRangeRequest) (*api.ReadRangeResponse, error) {
+	maxBytes := defaultReadRangeMaxBytes
+	if req.MaxBytes != 0 && req.MaxBytes < defaultReadRangeMaxBytes {
+		maxBytes = int(req.MaxBytes)
+	}
+
+	clog, err := s.commitLog(req.Topic, req.Partition)
+	if err != nil {
+		return nil, err
+	}
+
+	frames, first, next, err := clog.ReadRange(req.Offset, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.ReadRangeResponse{Frames: frames, FirstOffset: first, NextOffset: next}, nil
+}
+
+func (s *grpcServer) CreateTopic(_ context.Context, req *api.CreateTopicRequest) (*api.CreateTopicResponse, error) {
+	if s.TopicManager == nil {
+		return nil, errNoTopics
+	}
+
+	if err := s.TopicManager.CreateTopic(req.Topic, req.Partitions); err != nil {
+		return nil, err
+	}
+
+	return &api.CreateTopicResponse{}, nil
+}
+
+func (s *grpcServer) DeleteTopic(_ context.Context, req *api.DeleteTopicRequest) (*api.DeleteTopicResponse, error) {
+	if s.TopicManager == nil {
+		return nil, errNoTopics
+	}
+
+	if err := s.TopicManager.DeleteTopic(req.Topic); err != nil {
+		return nil, err
+	}
+
+	return &api.DeleteTopicResponse{}, nil
+}
+
+func (s *grpcServer) ListTopics(_ context.Context, _ *api.ListTopicsRequest) (*api.ListTopicsResponse, error) {
+	if s.TopicManager == nil {
+		return nil, errNoTopics
+	}
+
+	topics, err := s.TopicManager.Topics()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &api.ListTopicsResponse{}
+	for _, t := range topics {
+		res.Topics = append(res.Topics, &api.TopicInfo{Name: t.Name, Partitions: t.Partitions})
+	}
+
+	return res, nil
+}
+
+func (s *grpcServer) CommitOffset(_ context.Context, req *api.CommitOffsetRequest) (*api.CommitOffsetResponse, error) {
+	err := s.commitOffset(req.Group, req.MemberId, req.Generation, req.Topic, req.Partition, req.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.CommitOffsetResponse{}, nil
+}
+
+func (s *grpcServer) FetchOffset(_ context.Context, req *api.FetchOffsetRequest) (*api.FetchOffsetResponse, error) {
+	if s.OffsetManager == nil {
+		return nil, errNoGroups
+	}
+
+	offset, err := s.OffsetManager.FetchOffset(req.Group, req.Topic, req.Partition)
 	if err != nil {
 		return nil, err
 	}
 
-	return &api.ConsumeResponse{Record: &api.Record{Offset: record.Offset, Value: record.Value}}, nil
+	return &api.FetchOffsetResponse{Offset: offset}, nil
+}
+
+func (s *grpcServer) JoinGroup(_ context.Context, req *api.JoinGroupRequest) (*api.JoinGroupResponse, error) {
+	if s.GroupCoordinator == nil {
+		return nil, errNoGroups
+	}
+
+	sessionTimeout := time.Duration(req.SessionTimeoutMs) * time.Millisecond
+	m, err := s.GroupCoordinator.JoinGroup(req.Group, req.MemberId, req.Topics, req.Strategy, sessionTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &api.JoinGroupResponse{MemberId: m.MemberID, Generation: m.Generation}
+	for _, a := range m.Assignment {
+		res.Assignment = append(res.Assignment, &api.TopicPartitions{Topic: a.Topic, Partitions: a.Partitions})
+	}
+
+	return res, nil
+}
+
+func (s *grpcServer) Heartbeat(_ context.Context, req *api.HeartbeatRequest) (*api.HeartbeatResponse, error) {
+	if s.GroupCoordinator == nil {
+		return nil, errNoGroups
+	}
+
+	if err := s.GroupCoordinator.Heartbeat(req.Group, req.MemberId, req.Generation); err != nil {
+		return nil, err
+	}
+
+	return &api.HeartbeatResponse{}, nil
+}
+
+func (s *grpcServer) LeaveGroup(_ context.Context, req *api.LeaveGroupRequest) (*api.LeaveGroupResponse, error) {
+	if s.GroupCoordinator == nil {
+		return nil, errNoGroups
+	}
+
+	if err := s.GroupCoordinator.LeaveGroup(req.Group, req.MemberId); err != nil {
+		return nil, err
+	}
+
+	return &api.LeaveGroupResponse{}, nil
 }
 
 func (s *grpcServer) ProduceStream(stream api.Log_ProduceStreamServer) error {
@@ -85,9 +273,13 @@ func (s *grpcServer) ConsumeStream(req *api.ConsumeRequest, stream api.Log_Consu
 			return nil
 		default:
 			res, err := s.Consume(stream.Context(), req)
-			switch err.(type) {
+			switch e := err.(type) {
 			case nil:
 			case api.ErrOffsetOutOfRange:
+				// only the records not appended yet are waited for, the removed ones never come back
+				if e.Offset < e.Lowest {
+					return err
+				}
 				continue
 			default:
 				return err
@@ -96,7 +288,20 @@ func (s *grpcServer) ConsumeStream(req *api.ConsumeRequest, stream api.Log_Consu
 			if err := stream.Send(res); err != nil {
 				return err
 			}
-			req.Offset++
+			// compaction may leave gaps, so carry on after the record actually read
+			req.Offset = res.Record.Offset + 1
 		}
 	}
 }
+
+// copyRecord returns a copy of the produced record, so the commit log assigning its offset and timestamp does not
+// modify the request.
+func copyRecord(record *api.Record) *api.Record {
+	return &api.Record{
+		Value:     record.Value,
+		Offset:    record.Offset,
+		Timestamp: record.Timestamp,
+		Key:       record.Key,
+		Headers:   record.Headers,
+	}
+}
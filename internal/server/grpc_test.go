@@ -1,6 +1,7 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	logpkg "github.com/vlamug/pdlog/internal/log"
 	"google.golang.org/grpc/status"
@@ -8,10 +9,13 @@ import (
 	"net"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/vlamug/pdlog/api/v1"
+	"github.com/vlamug/pdlog/internal/group"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
@@ -23,6 +27,10 @@ func TestServer(t *testing.T) {
 		"produce/consume a message to/from the log succeeds": testProduceConsume,
 		"producer/consume stream succeeds":                   testProduceConsumeStream,
 		"consume past log boundary fails":                    testConsumePastBoundary,
+		"produce a batch succeeds":                           testProduceBatch,
+		"offset for time succeeds":                           testOffsetForTime,
+		"record fields are kept end to end":                  testRecordFields,
+		"read range succeeds":                                testReadRange,
 	} {
 		t.Run(scenario, func(t *testing.T) {
 			client, teardown := setupTest(t)
@@ -46,7 +54,7 @@ func setupTest(t *testing.T) (client api.LogClient, teardown func()) {
 	clog, err := logpkg.NewLog(dir, logpkg.Config{})
 	require.NoError(t, err)
 
-	srv, err := NewGRPCServer(clog)
+	srv, err := NewGRPCServer(&Config{CommitLog: clog})
 	require.NoError(t, err)
 
 	go func() {
@@ -147,7 +155,530 @@ func testConsumePastBoundary(t *testing.T, client api.LogClient) {
 		for i, record := range records {
 			res, err := stream.Recv()
 			require.NoError(t, err)
-			require.Equal(t, res.Record, &api.Record{Value: record.Value, Offset: uint64(i)})
+			require.Equal(t, record.Value, res.Record.Value)
+			require.Equal(t, uint64(i), res.Record.Offset)
+			// records produced without a timestamp are stamped by the log
+			require.NotZero(t, res.Record.Timestamp)
 		}
 	}
 }
+
+func testProduceBatch(t *testing.T, client api.LogClient) {
+	ctx := context.Background()
+
+	records := []*api.Record{
+		{Value: []byte("first message")},
+		{Value: []byte("second message")},
+	}
+	produceResp, err := client.ProduceBatch(ctx, &api.ProduceBatchRequest{Records: records})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 1}, produceResp.Offsets)
+
+	for i, offset := range produceResp.Offsets {
+		consumeResp, err := client.Consume(ctx, &api.ConsumeRequest{Offset: offset})
+		require.NoError(t, err)
+		require.Equal(t, records[i].Value, consumeResp.Record.Value)
+	}
+}
+
+func testOffsetForTime(t *testing.T, client api.LogClient) {
+	ctx := context.Background()
+
+	before := time.Now()
+	produceResp, err := client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello world")}})
+	require.NoError(t, err)
+
+	offsetResp, err := client.OffsetForTime(ctx, &api.OffsetForTimeRequest{Timestamp: before.UnixNano()})
+	require.NoError(t, err)
+	require.Equal(t, produceResp.Offset, offsetResp.Offset)
+
+	offsetResp, err = client.OffsetForTime(ctx, &api.OffsetForTimeRequest{Timestamp: time.Now().UnixNano()})
+	require.NoError(t, err)
+	require.Equal(t, produceResp.Offset+1, offsetResp.Offset)
+}
+
+func testRecordFields(t *testing.T, client api.LogClient) {
+	ctx := context.Background()
+	want := &api.Record{
+		Key:       []byte("user-1"),
+		Value:     []byte("hello world"),
+		Timestamp: time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC).UnixNano(),
+		Headers: []*api.Header{
+			{Key: "trace-id", Value: []byte("abc")},
+			{Key: "source", Value: []byte("billing")},
+		},
+	}
+
+	produceResp, err := client.Produce(ctx, &api.ProduceRequest{Record: want})
+	require.NoError(t, err)
+
+	batchResp, err := client.ProduceBatch(ctx, &api.ProduceBatchRequest{Records: []*api.Record{want}})
+	require.NoError(t, err)
+
+	produceStream, err := client.ProduceStream(ctx)
+	require.NoError(t, err)
+	require.NoError(t, produceStream.Send(&api.ProduceRequest{Record: want}))
+	streamResp, err := produceStream.Recv()
+	require.NoError(t, err)
+
+	offsets := []uint64{produceResp.Offset, batchResp.Offsets[0], streamResp.Offset}
+	consumeStream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: offsets[0]})
+	require.NoError(t, err)
+
+	for _, off := range offsets {
+		consumeResp, err := client.Consume(ctx, &api.ConsumeRequest{Offset: off})
+		require.NoError(t, err)
+
+		streamed, err := consumeStream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, consumeResp.Record.Key, streamed.Record.Key)
+
+		got := consumeResp.Record
+		require.Equal(t, off, got.Offset)
+		require.Equal(t, want.Key, got.Key)
+		require.Equal(t, want.Value, got.Value)
+		require.Equal(t, want.Timestamp, got.Timestamp)
+		require.Len(t, got.Headers, 2)
+		for i, h := range want.Headers {
+			require.Equal(t, h.Key, got.Headers[i].Key)
+			require.Equal(t, h.Value, got.Headers[i].Value)
+		}
+	}
+}
+
+func testReadRange(t *testing.T, client api.LogClient) {
+	ctx := context.Background()
+	values := [][]byte{[]byte("first message"), []byte("second message"), []byte("third message")}
+	for _, value := range values {
+		_, err := client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: value}})
+		require.NoError(t, err)
+	}
+
+	res, err := client.ReadRange(ctx, &api.ReadRangeRequest{Offset: 1})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), res.FirstOffset)
+	require.Equal(t, uint64(3), res.NextOffset)
+
+	dec := logpkg.NewDecoder(bytes.NewReader(res.Frames))
+	for off := res.FirstOffset; off < res.NextOffset; off++ {
+		record, err := dec.Decode()
+		require.NoError(t, err)
+		require.Equal(t, off, record.Offset)
+		require.Equal(t, values[off], record.Value)
+	}
+
+	_, err = client.ReadRange(ctx, &api.ReadRangeRequest{Offset: res.NextOffset})
+	require.Equal(t, status.Code(api.ErrOffsetOutOfRange{}.GRPCStatus().Err()), status.Code(err))
+}
+
+// topicManager hands the topics and the committed offsets of a log manager to the server, as the agent does.
+type topicManager struct {
+	*logpkg.LogManager
+}
+
+func (m topicManager) Topic(name string, create bool) (Topic, error) {
+	get := m.LogManager.Topic
+	if create {
+		get = m.EnsureTopic
+	}
+
+	t, err := get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return topic{t}, nil
+}
+
+func (m topicManager) CreateTopic(name string, partitions uint32) error {
+	_, err := m.LogManager.CreateTopic(name, int(partitions))
+	return err
+}
+
+func (m topicManager) Topics() ([]TopicInfo, error) {
+	topics, err := m.LogManager.Topics()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]TopicInfo, len(topics))
+	for i, t := range topics {
+		infos[i] = TopicInfo{Name: t.Name, Partitions: uint32(t.Partitions)}
+	}
+
+	return infos, nil
+}
+
+func (m topicManager) CommitOffset(group, topic string, partition uint32, offset uint64) error {
+	return m.LogManager.CommitOffset(group, topic, int(partition), offset)
+}
+
+func (m topicManager) FetchOffset(group, topic string, partition uint32) (uint64, error) {
+	return m.LogManager.FetchOffset(group, topic, int(partition))
+}
+
+func (m topicManager) Partitions(name string) (int, error) {
+	t, err := m.LogManager.Topic(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.Partitions(), nil
+}
+
+type topic struct {
+	*logpkg.Topic
+}
+
+func (t topic) Partition(p uint32) (CommitLog, error) {
+	l, err := t.Topic.Partition(int(p))
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (t topic) PartitionFor(record *api.Record) uint32 {
+	return uint32(t.Topic.PartitionFor(record.Key))
+}
+
+func TestServerTopics(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+
+	cc, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+
+	dir, err := os.MkdirTemp("", "server-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	logs, err := logpkg.NewLogManager(dir, logpkg.Config{}, nil)
+	require.NoError(t, err)
+	defer logs.Close()
+
+	srv, err := NewGRPCServer(&Config{TopicManager: topicManager{logs}})
+	require.NoError(t, err)
+	go func() {
+		srv.Serve(l)
+	}()
+	defer srv.Stop()
+
+	ctx := context.Background()
+	client := api.NewLogClient(cc)
+
+	// consuming from a missing topic fails, producing creates it
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Topic: "orders"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	for _, topic := range []string{"", "orders", "orders"} {
+		_, err = client.Produce(ctx, &api.ProduceRequest{Topic: topic, Record: &api.Record{Value: []byte(topic)}})
+		require.NoError(t, err)
+	}
+
+	res, err := client.Consume(ctx, &api.ConsumeRequest{Topic: "orders", Offset: 1})
+	require.NoError(t, err)
+	require.Equal(t, []byte("orders"), res.Record.Value)
+	res, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	require.Empty(t, res.Record.Value)
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 1})
+	require.Equal(t, status.Code(api.ErrOffsetOutOfRange{}.GRPCStatus().Err()), status.Code(err))
+
+	_, err = client.CreateTopic(ctx, &api.CreateTopicRequest{Topic: "users"})
+	require.NoError(t, err)
+	_, err = client.CreateTopic(ctx, &api.CreateTopicRequest{Topic: "users"})
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+	_, err = client.CreateTopic(ctx, &api.CreateTopicRequest{Topic: "../users"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	list, err := client.ListTopics(ctx, &api.ListTopicsRequest{})
+	require.NoError(t, err)
+	var topics []string
+	for _, topic := range list.Topics {
+		require.Equal(t, uint32(1), topic.Partitions)
+		topics = append(topics, topic.Name)
+	}
+	require.Equal(t, []string{logpkg.DefaultTopic, "orders", "users"}, topics)
+
+	_, err = client.DeleteTopic(ctx, &api.DeleteTopicRequest{Topic: "orders"})
+	require.NoError(t, err)
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Topic: "orders"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestServerPartitions(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+
+	cc, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+
+	dir, err := os.MkdirTemp("", "server-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	logs, err := logpkg.NewLogManager(dir, logpkg.Config{}, nil)
+	require.NoError(t, err)
+	defer logs.Close()
+
+	srv, err := NewGRPCServer(&Config{TopicManager: topicManager{logs}})
+	require.NoError(t, err)
+	go func() {
+		srv.Serve(l)
+	}()
+	defer srv.Stop()
+
+	ctx := context.Background()
+	client := api.NewLogClient(cc)
+
+	_, err = client.CreateTopic(ctx, &api.CreateTopicRequest{Topic: "orders", Partitions: 3})
+	require.NoError(t, err)
+
+	// the records of a key go to the same partition, in order, the ones without key to every partition in turn
+	records := []*api.Record{
+		{Key: []byte("user-1"), Value: []byte("first")},
+		{Value: []byte("a")},
+		{Key: []byte("user-1"), Value: []byte("second")},
+		{Value: []byte("b")},
+		{Value: []byte("c")},
+	}
+	res, err := client.ProduceBatch(ctx, &api.ProduceBatchRequest{Topic: "orders", Records: records})
+	require.NoError(t, err)
+	require.Equal(t, res.Partitions[0], res.Partitions[2])
+	require.Less(t, res.Offsets[0], res.Offsets[2])
+	require.ElementsMatch(t, []uint32{0, 1, 2}, []uint32{res.Partitions[1], res.Partitions[3], res.Partitions[4]})
+
+	produced, err := client.Produce(ctx, &api.ProduceRequest{Topic: "orders", Record: records[0]})
+	require.NoError(t, err)
+	require.Equal(t, res.Partitions[0], produced.Partition)
+	require.Less(t, res.Offsets[2], produced.Offset)
+
+	for i, record := range records {
+		consumed, err := client.Consume(ctx, &api.ConsumeRequest{
+			Topic:     "orders",
+			Partition: res.Partitions[i],
+			Offset:    res.Offsets[i],
+		})
+		require.NoError(t, err)
+		require.Equal(t, record.Value, consumed.Record.Value)
+	}
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Topic: "orders", Partition: 3})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	list, err := client.ListTopics(ctx, &api.ListTopicsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, "orders", list.Topics[1].Name)
+	require.Equal(t, uint32(3), list.Topics[1].Partitions)
+}
+
+func TestConsumeStreamRemoved(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+
+	cc, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+
+	dir, err := os.MkdirTemp("", "server-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := logpkg.Config{}
+	// two records per segment
+	c.Segment.MaxIndexBytes = 24
+	clog, err := logpkg.NewLog(dir, c)
+	require.NoError(t, err)
+	defer clog.Close()
+
+	srv, err := NewGRPCServer(&Config{CommitLog: clog})
+	require.NoError(t, err)
+	go func() {
+		srv.Serve(l)
+	}()
+	defer srv.Stop()
+
+	ctx := context.Background()
+	client := api.NewLogClient(cc)
+
+	for i := 0; i < 4; i++ {
+		_, err = client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello world")}})
+		require.NoError(t, err)
+	}
+	require.NoError(t, clog.Truncate(1))
+
+	// the stream ends instead of waiting for records which were removed
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, status.Code(api.ErrOffsetOutOfRange{}.GRPCStatus().Err()), status.Code(err))
+
+	// clients can carry on from the lowest offset
+	lowest, ok := api.LowestOffset(err)
+	require.True(t, ok)
+	require.Equal(t, uint64(2), lowest)
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 4})
+	_, ok = api.LowestOffset(err)
+	require.False(t, ok)
+}
+
+func TestServerOffsets(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+
+	cc, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+
+	dir, err := os.MkdirTemp("", "server-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	logs, err := logpkg.NewLogManager(dir, logpkg.Config{}, nil)
+	require.NoError(t, err)
+	defer logs.Close()
+
+	srv, err := NewGRPCServer(&Config{TopicManager: topicManager{logs}, OffsetManager: topicManager{logs}})
+	require.NoError(t, err)
+	go func() {
+		srv.Serve(l)
+	}()
+	defer srv.Stop()
+
+	ctx := context.Background()
+	client := api.NewLogClient(cc)
+
+	_, err = client.CreateTopic(ctx, &api.CreateTopicRequest{Topic: "orders", Partitions: 2})
+	require.NoError(t, err)
+
+	_, err = client.FetchOffset(ctx, &api.FetchOffsetRequest{Group: "billing", Topic: "orders", Partition: 1})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.CommitOffset(ctx, &api.CommitOffsetRequest{Group: "billing", Topic: "orders", Partition: 1, Offset: 3})
+	require.NoError(t, err)
+	res, err := client.FetchOffset(ctx, &api.FetchOffsetRequest{Group: "billing", Topic: "orders", Partition: 1})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), res.Offset)
+
+	// the offsets are kept per group and partition
+	_, err = client.FetchOffset(ctx, &api.FetchOffsetRequest{Group: "shipping", Topic: "orders", Partition: 1})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.FetchOffset(ctx, &api.FetchOffsetRequest{Group: "billing", Topic: "orders"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.CommitOffset(ctx, &api.CommitOffsetRequest{Topic: "orders", Offset: 3})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.CommitOffset(ctx, &api.CommitOffsetRequest{Group: "billing", Topic: "orders", Partition: 2})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+// groupCoordinator hands a group coordinator to the server, as the agent does.
+type groupCoordinator struct {
+	*group.Coordinator
+}
+
+func (c groupCoordinator) JoinGroup(name, memberID string, topics []string, strategy string, sessionTimeout time.Duration) (Membership, error) {
+	m, err := c.Join(name, memberID, topics, group.Strategy(strategy), sessionTimeout)
+	if err != nil {
+		return Membership{}, err
+	}
+
+	membership := Membership{MemberID: m.MemberID, Generation: m.Generation}
+	for _, a := range m.Assignment {
+		partitions := make([]uint32, len(a.Partitions))
+		for i, p := range a.Partitions {
+			partitions[i] = uint32(p)
+		}
+		membership.Assignment = append(membership.Assignment, TopicPartitions{Topic: a.Topic, Partitions: partitions})
+	}
+
+	return membership, nil
+}
+
+func (c groupCoordinator) LeaveGroup(name, memberID string) error {
+	return c.Leave(name, memberID)
+}
+
+func (c groupCoordinator) CommitOffset(name, memberID string, generation uint64, topic string, partition uint32, offset uint64) error {
+	return c.Coordinator.CommitOffset(name, memberID, generation, topic, int(partition), offset)
+}
+
+func TestServerGroups(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+
+	cc, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+
+	dir, err := os.MkdirTemp("", "server-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	logs, err := logpkg.NewLogManager(dir, logpkg.Config{}, nil)
+	require.NoError(t, err)
+	defer logs.Close()
+
+	srv, err := NewGRPCServer(&Config{
+		TopicManager:     topicManager{logs},
+		OffsetManager:    topicManager{logs},
+		GroupCoordinator: groupCoordinator{group.New(topicManager{logs}, logs, &group.Config{})},
+	})
+	require.NoError(t, err)
+	go func() {
+		srv.Serve(l)
+	}()
+	defer srv.Stop()
+
+	ctx := context.Background()
+	client := api.NewLogClient(cc)
+
+	_, err = client.CreateTopic(ctx, &api.CreateTopicRequest{Topic: "orders", Partitions: 3})
+	require.NoError(t, err)
+
+	join := &api.JoinGroupRequest{Group: "billing", Topics: []string{"orders"}, Strategy: string(group.RoundRobin)}
+	a, err := client.JoinGroup(ctx, join)
+	require.NoError(t, err)
+	require.Equal(t, []uint32{0, 1, 2}, a.Assignment[0].Partitions)
+
+	b, err := client.JoinGroup(ctx, join)
+	require.NoError(t, err)
+	require.Equal(t, a.Generation+1, b.Generation)
+
+	// the first member lost some partitions, its commits are rejected until it joins again
+	_, err = client.Heartbeat(ctx, &api.HeartbeatRequest{Group: "billing", MemberId: a.MemberId, Generation: a.Generation})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	commit := &api.CommitOffsetRequest{Group: "billing", Topic: "orders", MemberId: a.MemberId, Generation: a.Generation, Offset: 5}
+	_, err = client.CommitOffset(ctx, commit)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	a, err = client.JoinGroup(ctx, &api.JoinGroupRequest{Group: "billing", MemberId: a.MemberId, Topics: []string{"orders"}, Strategy: join.Strategy})
+	require.NoError(t, err)
+	require.Equal(t, b.Generation, a.Generation)
+	require.ElementsMatch(t, []uint32{0, 1, 2}, append(a.Assignment[0].Partitions, b.Assignment[0].Partitions...))
+
+	commit.Generation = a.Generation
+	commit.Partition = a.Assignment[0].Partitions[0]
+	_, err = client.CommitOffset(ctx, commit)
+	require.NoError(t, err)
+	fetched, err := client.FetchOffset(ctx, &api.FetchOffsetRequest{Group: "billing", Topic: "orders", Partition: commit.Partition})
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), fetched.Offset)
+
+	_, err = client.LeaveGroup(ctx, &api.LeaveGroupRequest{Group: "billing", MemberId: b.MemberId})
+	require.NoError(t, err)
+	_, err = client.Heartbeat(ctx, &api.HeartbeatRequest{Group: "billing", MemberId: b.MemberId, Generation: b.Generation})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	a, err = client.JoinGroup(ctx, &api.JoinGroupRequest{Group: "billing", MemberId: a.MemberId, Topics: []string{"orders"}, Strategy: join.Strategy})
+	require.NoError(t, err)
+	require.Equal(t, []uint32{0, 1, 2}, a.Assignment[0].Partitions)
+
+	// consumers outside the group cannot commit while it has members
+	_, err = client.CommitOffset(ctx, &api.CommitOffsetRequest{Group: "billing", Topic: "orders", Offset: 6})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
@@ -2,7 +2,9 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/vlamug/pdlog/api/v1"
@@ -17,6 +19,16 @@ func NewHTTPServer(addr string, serverConfig *Config) (*http.Server, error) {
 	r := mux.NewRouter()
 	r.HandleFunc("/", srv.handleProduce).Methods(http.MethodPost)
 	r.HandleFunc("/", srv.handleConsume).Methods(http.MethodGet)
+	r.HandleFunc("/batch", srv.handleProduceBatch).Methods(http.MethodPost)
+	r.HandleFunc("/offset", srv.handleOffsetForTime).Methods(http.MethodGet)
+	r.HandleFunc("/topics", srv.handleListTopics).Methods(http.MethodGet)
+	r.HandleFunc("/topics", srv.handleCreateTopic).Methods(http.MethodPost)
+	r.HandleFunc("/topics/{topic}", srv.handleDeleteTopic).Methods(http.MethodDelete)
+	r.HandleFunc("/groups/{group}/offsets", srv.handleCommitOffset).Methods(http.MethodPost)
+	r.HandleFunc("/groups/{group}/offsets", srv.handleFetchOffset).Methods(http.MethodGet)
+	r.HandleFunc("/groups/{group}/members", srv.handleJoinGroup).Methods(http.MethodPost)
+	r.HandleFunc("/groups/{group}/members/{member}/heartbeat", srv.handleHeartbeat).Methods(http.MethodPost)
+	r.HandleFunc("/groups/{group}/members/{member}", srv.handleLeaveGroup).Methods(http.MethodDelete)
 
 	return &http.Server{
 		Addr:    addr,
@@ -34,26 +46,174 @@ func newHTTPServer(config *Config) (*httpServer, error) {
 
 // Record contains log item
 type Record struct {
+	Key    string `json:"key,omitempty"`
 	Value  string `json:"value"`
 	Offset uint64 `json:"offset"`
+	// Timestamp is set by the log on append when left unset.
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Headers   []Header   `json:"headers,omitempty"`
 }
 
+type Header struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// toAPI converts the record into its protobuf form.
+func (r *Record) toAPI() *api.Record {
+	record := &api.Record{
+		Key:    []byte(r.Key),
+		Value:  []byte(r.Value),
+		Offset: r.Offset,
+	}
+	if r.Timestamp != nil && !r.Timestamp.IsZero() {
+		record.Timestamp = r.Timestamp.UnixNano()
+	}
+	for _, h := range r.Headers {
+		record.Headers = append(record.Headers, &api.Header{Key: h.Key, Value: []byte(h.Value)})
+	}
+
+	return record
+}
+
+// newRecord converts the record from its protobuf form.
+func newRecord(record *api.Record) *Record {
+	r := &Record{
+		Key:    string(record.Key),
+		Value:  string(record.Value),
+		Offset: record.Offset,
+	}
+	// records appended before the log set timestamps have none
+	if record.Timestamp != 0 {
+		ts := time.Unix(0, record.Timestamp).UTC()
+		r.Timestamp = &ts
+	}
+	for _, h := range record.Headers {
+		r.Headers = append(r.Headers, Header{Key: h.Key, Value: string(h.Value)})
+	}
+
+	return r
+}
+
+// The requests name the topic they are about, the default one when omitted. Reads name the partition as well, the
+// produced records are routed to theirs by key.
+
 type ProduceRequest struct {
 	Record *Record `json:"record"`
+	Topic  string  `json:"topic,omitempty"`
 }
 
 type ProducerResponse struct {
+	Offset    uint64 `json:"offset"`
+	Partition uint32 `json:"partition"`
+}
+
+type ProduceBatchRequest struct {
+	Records []*Record `json:"records"`
+	Topic   string    `json:"topic,omitempty"`
+}
+
+type ProduceBatchResponse struct {
+	Offsets    []uint64 `json:"offsets"`
+	Partitions []uint32 `json:"partitions"`
+}
+
+type OffsetForTimeRequest struct {
+	Timestamp time.Time `json:"timestamp"`
+	Topic     string    `json:"topic,omitempty"`
+	Partition uint32    `json:"partition,omitempty"`
+}
+
+type OffsetForTimeResponse struct {
 	Offset uint64 `json:"offset"`
 }
 
 type ConsumeRequest struct {
-	Offset uint64 `json:"offset"`
+	Offset    uint64 `json:"offset"`
+	Topic     string `json:"topic,omitempty"`
+	Partition uint32 `json:"partition,omitempty"`
 }
 
 type ConsumeResponse struct {
 	Record *Record `json:"record"`
 }
 
+type CreateTopicRequest struct {
+	Topic string `json:"topic"`
+	// Partitions is the number of partitions of the topic, the configured one when omitted.
+	Partitions uint32 `json:"partitions,omitempty"`
+}
+
+type TopicResponse struct {
+	Name       string `json:"name"`
+	Partitions uint32 `json:"partitions"`
+}
+
+type ListTopicsResponse struct {
+	Topics []TopicResponse `json:"topics"`
+}
+
+// CommitOffsetRequest commits the offset of the next record the group named in the path consumes from the partition.
+// The members of a group commit with their id and the generation of their assignment.
+type CommitOffsetRequest struct {
+	Topic      string `json:"topic,omitempty"`
+	Partition  uint32 `json:"partition,omitempty"`
+	Offset     uint64 `json:"offset"`
+	MemberID   string `json:"member_id,omitempty"`
+	Generation uint64 `json:"generation,omitempty"`
+}
+
+type FetchOffsetRequest struct {
+	Topic     string `json:"topic,omitempty"`
+	Partition uint32 `json:"partition,omitempty"`
+}
+
+type FetchOffsetResponse struct {
+	Offset uint64 `json:"offset"`
+}
+
+// JoinGroupRequest joins the group named in the path, a new member joining without id.
+type JoinGroupRequest struct {
+	MemberID string   `json:"member_id,omitempty"`
+	Topics   []string `json:"topics"`
+	Strategy string   `json:"strategy,omitempty"`
+	// SessionTimeout is how long the member stays in the group without heartbeat, the configured one when omitted.
+	SessionTimeout Duration `json:"session_timeout,omitempty"`
+}
+
+type JoinGroupResponse struct {
+	MemberID   string                    `json:"member_id"`
+	Generation uint64                    `json:"generation"`
+	Assignment []TopicPartitionsResponse `json:"assignment"`
+}
+
+type TopicPartitionsResponse struct {
+	Topic      string   `json:"topic"`
+	Partitions []uint32 `json:"partitions"`
+}
+
+type HeartbeatRequest struct {
+	Generation uint64 `json:"generation"`
+}
+
+// Duration is a duration written as a string such as "30s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(p []byte) error {
+	var s string
+	if err := json.Unmarshal(p, &s); err != nil {
+		return err
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+
+	return nil
+}
+
 func (s *httpServer) handleProduce(w http.ResponseWriter, r *http.Request) {
 	var req ProduceRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -61,18 +221,87 @@ func (s *httpServer) handleProduce(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	record := &api.Record{
-		Value:  []byte(req.Record.Value),
-		Offset: req.Record.Offset,
+	if req.Record == nil {
+		http.Error(w, "record is missing", http.StatusBadRequest)
+		return
+	}
+
+	t, err := s.topic(req.Topic, true)
+	if err != nil {
+		http.Error(w, err.Error(), errStatus(err))
+		return
+	}
+
+	record := req.Record.toAPI()
+	p := t.PartitionFor(record)
+	clog, err := t.Partition(p)
+	if err != nil {
+		http.Error(w, err.Error(), errStatus(err))
+		return
+	}
+
+	offset, err := clog.Append(record)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res := ProducerResponse{Offset: offset, Partition: p}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *httpServer) handleProduceBatch(w http.ResponseWriter, r *http.Request) {
+	var req ProduceBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	offset, err := s.CommitLog.Append(record)
+	records := make([]*api.Record, len(req.Records))
+	for i, record := range req.Records {
+		if record == nil {
+			http.Error(w, fmt.Sprintf("record %d is missing", i), http.StatusBadRequest)
+			return
+		}
+		records[i] = record.toAPI()
+	}
+
+	offsets, partitions, err := s.produceBatch(req.Topic, records)
 	if err != nil {
+		http.Error(w, err.Error(), errStatus(err))
+		return
+	}
+
+	res := ProduceBatchResponse{Offsets: offsets, Partitions: partitions}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+}
+
+func (s *httpServer) handleOffsetForTime(w http.ResponseWriter, r *http.Request) {
+	var req OffsetForTimeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	res := ProducerResponse{Offset: offset}
+	clog, err := s.commitLog(req.Topic, req.Partition)
+	if err != nil {
+		http.Error(w, err.Error(), errStatus(err))
+		return
+	}
+
+	offset, err := clog.OffsetForTime(req.Timestamp)
+	if err != nil {
+		http.Error(w, err.Error(), errStatus(err))
+		return
+	}
+
+	res := OffsetForTimeResponse{Offset: offset}
 	if err := json.NewEncoder(w).Encode(res); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -86,18 +315,207 @@ func (s *httpServer) handleConsume(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	record, err := s.CommitLog.Read(req.Offset)
+	clog, err := s.commitLog(req.Topic, req.Partition)
 	if err != nil {
+		http.Error(w, err.Error(), errStatus(err))
+		return
+	}
+
+	record, err := clog.Read(req.Offset)
+	if err != nil {
+		http.Error(w, err.Error(), errStatus(err))
+		return
+	}
+
+	res := ConsumeResponse{Record: newRecord(record)}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+}
 
-	res := ConsumeResponse{Record: &Record{
-		Value:  string(record.Value),
-		Offset: record.Offset,
-	}}
+func (s *httpServer) handleListTopics(w http.ResponseWriter, _ *http.Request) {
+	if s.TopicManager == nil {
+		http.Error(w, errNoTopics.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	topics, err := s.TopicManager.Topics()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res := ListTopicsResponse{Topics: []TopicResponse{}}
+	for _, t := range topics {
+		res.Topics = append(res.Topics, TopicResponse{Name: t.Name, Partitions: t.Partitions})
+	}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *httpServer) handleCreateTopic(w http.ResponseWriter, r *http.Request) {
+	if s.TopicManager == nil {
+		http.Error(w, errNoTopics.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	var req CreateTopicRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.TopicManager.CreateTopic(req.Topic, req.Partitions); err != nil {
+		http.Error(w, err.Error(), errStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *httpServer) handleDeleteTopic(w http.ResponseWriter, r *http.Request) {
+	if s.TopicManager == nil {
+		http.Error(w, errNoTopics.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	if err := s.TopicManager.DeleteTopic(mux.Vars(r)["topic"]); err != nil {
+		http.Error(w, err.Error(), errStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *httpServer) handleCommitOffset(w http.ResponseWriter, r *http.Request) {
+	var req CommitOffsetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := s.commitOffset(mux.Vars(r)["group"], req.MemberID, req.Generation, req.Topic, req.Partition, req.Offset)
+	if err != nil {
+		http.Error(w, err.Error(), errStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *httpServer) handleFetchOffset(w http.ResponseWriter, r *http.Request) {
+	if s.OffsetManager == nil {
+		http.Error(w, errNoGroups.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	var req FetchOffsetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	offset, err := s.OffsetManager.FetchOffset(mux.Vars(r)["group"], req.Topic, req.Partition)
+	if err != nil {
+		http.Error(w, err.Error(), errStatus(err))
+		return
+	}
+
+	res := FetchOffsetResponse{Offset: offset}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *httpServer) handleJoinGroup(w http.ResponseWriter, r *http.Request) {
+	if s.GroupCoordinator == nil {
+		http.Error(w, errNoGroups.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	var req JoinGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m, err := s.GroupCoordinator.JoinGroup(
+		mux.Vars(r)["group"], req.MemberID, req.Topics, req.Strategy, time.Duration(req.SessionTimeout),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), errStatus(err))
+		return
+	}
+
+	res := JoinGroupResponse{MemberID: m.MemberID, Generation: m.Generation, Assignment: []TopicPartitionsResponse{}}
+	for _, a := range m.Assignment {
+		res.Assignment = append(res.Assignment, TopicPartitionsResponse{Topic: a.Topic, Partitions: a.Partitions})
+	}
 	if err := json.NewEncoder(w).Encode(res); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
+
+func (s *httpServer) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	if s.GroupCoordinator == nil {
+		http.Error(w, errNoGroups.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	var req HeartbeatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	vars := mux.Vars(r)
+	if err := s.GroupCoordinator.Heartbeat(vars["group"], vars["member"], req.Generation); err != nil {
+		http.Error(w, err.Error(), errStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *httpServer) handleLeaveGroup(w http.ResponseWriter, r *http.Request) {
+	if s.GroupCoordinator == nil {
+		http.Error(w, errNoGroups.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	vars := mux.Vars(r)
+	if err := s.GroupCoordinator.LeaveGroup(vars["group"], vars["member"]); err != nil {
+		http.Error(w, err.Error(), errStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// errStatus maps the commit log errors to http status codes. Corrupted records are reported apart from the other
+// failures, so consumers can tell a record they may skip from a server error.
+func errStatus(err error) int {
+	if err == errNoGroups {
+		return http.StatusNotImplemented
+	}
+
+	switch err.(type) {
+	case api.ErrOffsetOutOfRange:
+		return http.StatusNotFound
+	case api.ErrCorruptRecord:
+		return http.StatusUnprocessableEntity
+	case api.ErrBatchTooLarge, api.ErrInvalidTopic, api.ErrInvalidGroup:
+		return http.StatusBadRequest
+	case api.ErrTopicNotFound, api.ErrPartitionNotFound, api.ErrOffsetNotCommitted, api.ErrUnknownMember:
+		return http.StatusNotFound
+	case api.ErrTopicExists, api.ErrIllegalGeneration:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vlamug/pdlog/api/v1"
+	logpkg "github.com/vlamug/pdlog/internal/log"
+)
+
+func TestHTTPRecordTimestamp(t *testing.T) {
+	p, err := json.Marshal(newRecord(&api.Record{Value: []byte("hello world")}))
+	require.NoError(t, err)
+	require.NotContains(t, string(p), "timestamp")
+
+	ts := time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+	p, err = json.Marshal(newRecord(&api.Record{Value: []byte("hello world"), Timestamp: ts.UnixNano()}))
+	require.NoError(t, err)
+
+	var got Record
+	require.NoError(t, json.Unmarshal(p, &got))
+	require.NotNil(t, got.Timestamp)
+	require.True(t, ts.Equal(*got.Timestamp))
+	require.Equal(t, ts.UnixNano(), got.toAPI().Timestamp)
+}
+
+func TestHTTPRecordFields(t *testing.T) {
+	dir := t.TempDir()
+	clog, err := logpkg.NewLog(dir, logpkg.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+
+	srv, err := NewHTTPServer(":0", &Config{CommitLog: clog})
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	want := &Record{
+		Key:     "user-1",
+		Value:   "hello world",
+		Headers: []Header{{Key: "trace-id", Value: "abc"}},
+	}
+
+	var produced ProducerResponse
+	doHTTP(t, http.MethodPost, ts.URL, ProduceRequest{Record: want}, &produced)
+
+	var batch ProduceBatchResponse
+	doHTTP(t, http.MethodPost, ts.URL+"/batch", ProduceBatchRequest{Records: []*Record{want}}, &batch)
+
+	for _, off := range []uint64{produced.Offset, batch.Offsets[0]} {
+		var res ConsumeResponse
+		doHTTP(t, http.MethodGet, ts.URL, ConsumeRequest{Offset: off}, &res)
+
+		require.Equal(t, off, res.Record.Offset)
+		require.Equal(t, want.Key, res.Record.Key)
+		require.Equal(t, want.Value, res.Record.Value)
+		require.Equal(t, want.Headers, res.Record.Headers)
+		require.NotNil(t, res.Record.Timestamp)
+	}
+}
+
+func TestHTTPMissingRecord(t *testing.T) {
+	clog, err := logpkg.NewLog(t.TempDir(), logpkg.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+
+	srv, err := NewHTTPServer(":0", &Config{CommitLog: clog})
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	for url, body := range map[string]string{
+		ts.URL:            `{}`,
+		ts.URL + "/batch": `{"records": [{"value": "hello world"}, null]}`,
+	} {
+		require.Equal(t, http.StatusBadRequest, statusHTTP(t, http.MethodPost, url, body))
+	}
+
+	// nothing of the batch was appended
+	_, err = clog.Read(0)
+	require.Error(t, err)
+}
+
+// corruptLog fails to read every record as if it was corrupted on disk.
+type corruptLog struct {
+	CommitLog
+}
+
+func (l corruptLog) Read(off uint64) (*api.Record, error) {
+	return nil, api.ErrCorruptRecord{Offset: off}
+}
+
+func TestHTTPErrStatus(t *testing.T) {
+	clog, err := logpkg.NewLog(t.TempDir(), logpkg.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+
+	for scenario, tc := range map[string]struct {
+		clog CommitLog
+		want int
+	}{
+		"offset out of range": {clog: clog, want: http.StatusNotFound},
+		"corrupt record":      {clog: corruptLog{clog}, want: http.StatusUnprocessableEntity},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			srv, err := NewHTTPServer(":0", &Config{CommitLog: tc.clog})
+			require.NoError(t, err)
+			ts := httptest.NewServer(srv.Handler)
+			defer ts.Close()
+
+			require.Equal(t, tc.want, statusHTTP(t, http.MethodGet, ts.URL, `{"offset": 0}`))
+		})
+	}
+}
+
+// doHTTP sends the request encoded in JSON and decodes the response into res.
+func doHTTP(t *testing.T, method, url string, req, res any) {
+	t.Helper()
+
+	p, err := json.Marshal(req)
+	require.NoError(t, err)
+	r, err := http.NewRequest(method, url, bytes.NewReader(p))
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(r)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(res))
+}
+
+// statusHTTP sends the body and returns the status code of the response.
+func statusHTTP(t *testing.T, method, url, body string) int {
+	t.Helper()
+
+	r, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(r)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	return resp.StatusCode
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"github.com/vlamug/pdlog/api/v1"
+)
+
+// Topic is a commit log split into partitions.
+type Topic interface {
+	Partitions() int
+	// Partition returns the commit log of the partition, ErrPartitionNotFound when the topic has no such partition.
+	Partition(p uint32) (CommitLog, error)
+	// PartitionFor returns the partition the record is produced to.
+	PartitionFor(record *api.Record) uint32
+}
+
+// TopicInfo describes a topic.
+type TopicInfo struct {
+	Name       string
+	Partitions uint32
+}
+
+// TopicManager hands out the topics, the empty topic being the default one.
+type TopicManager interface {
+	// Topic returns the topic, create tells to create a missing topic rather than failing with ErrTopicNotFound.
+	Topic(topic string, create bool) (Topic, error)
+	// CreateTopic creates the topic with the given number of partitions, zero meaning the configured one.
+	CreateTopic(topic string, partitions uint32) error
+	DeleteTopic(topic string) error
+	Topics() ([]TopicInfo, error)
+}
+
+// singlePartition is the only topic of a server serving a single commit log.
+type singlePartition struct {
+	CommitLog
+}
+
+func (t singlePartition) Partitions() int {
+	return 1
+}
+
+func (t singlePartition) Partition(p uint32) (CommitLog, error) {
+	if p != 0 {
+		return nil, api.ErrPartitionNotFound{Partition: p}
+	}
+
+	return t.CommitLog, nil
+}
+
+func (t singlePartition) PartitionFor(*api.Record) uint32 {
+	return 0
+}
+
+// topic returns the topic of the request. Producing to a topic creates it, reading from a missing one fails.
+func (c *Config) topic(topic string, create bool) (Topic, error) {
+	if c.TopicManager != nil {
+		return c.TopicManager.Topic(topic, create)
+	}
+	if topic != "" {
+		return nil, api.ErrTopicNotFound{Topic: topic}
+	}
+
+	return singlePartition{c.CommitLog}, nil
+}
+
+// commitLog returns the log of the partition the request reads from.
+func (c *Config) commitLog(topic string, partition uint32) (CommitLog, error) {
+	t, err := c.topic(topic, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.Partition(partition)
+}
+
+// produceBatch appends the records to the partitions picked by the topic and returns their offsets and partitions. The
+// records of a partition are appended at once, in order, but a failure leaves the ones appended to the other
+// partitions.
+func (c *Config) produceBatch(topic string, records []*api.Record) ([]uint64, []uint32, error) {
+	t, err := c.topic(topic, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	partitions := make([]uint32, len(records))
+	// the records are grouped by partition, in the order of their first record
+	var order []uint32
+	groups := make(map[uint32][]int)
+	for i, record := range records {
+		p := t.PartitionFor(record)
+		if _, ok := groups[p]; !ok {
+			order = append(order, p)
+		}
+		groups[p] = append(groups[p], i)
+		partitions[i] = p
+	}
+
+	offsets := make([]uint64, len(records))
+	for _, p := range order {
+		clog, err := t.Partition(p)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		batch := make([]*api.Record, len(groups[p]))
+		for j, i := range groups[p] {
+			batch[j] = records[i]
+		}
+		appended, err := clog.AppendBatch(batch)
+		if err != nil {
+			return nil, nil, err
+		}
+		for j, i := range groups[p] {
+			offsets[i] = appended[j]
+		}
+	}
+
+	return offsets, partitions, nil
+}